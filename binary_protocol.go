@@ -0,0 +1,114 @@
+package k8s_exec_pod
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/websocket"
+)
+
+// BinarySubprotocol is the websocket subprotocol of the binary framing. A client requesting it by the
+// Sec-WebSocket-Protocol header sends the TermMsg as the websocket.BinaryMessage of a 1-byte BinaryOpcode
+// and the payload, the websocket.TextMessage would still be parsed as the JSON TermMsg.
+// The JSON TermMsg is the default if the subprotocol was not requested.
+const BinarySubprotocol = "k8s-exec-pod.binary.v1"
+
+// BinaryOpcode is the first byte of a binary framed message
+type BinaryOpcode byte
+
+const (
+	// BinaryInput carries the raw bytes of the stdin
+	BinaryInput BinaryOpcode = 0x00
+	// BinaryResize carries the big-endian uint16 cols and rows
+	BinaryResize BinaryOpcode = 0x01
+	BinaryPing   BinaryOpcode = 0x02
+	BinaryPause  BinaryOpcode = 0x03
+	BinaryResume BinaryOpcode = 0x04
+	// BinaryFilter carries the JSON LogFilterSpec, an empty payload clears the filter
+	BinaryFilter BinaryOpcode = 0x05
+)
+
+const (
+	ErrBinaryOpcodeNotSupported = "error: the binary opcode:%#x was not supported"
+)
+
+// decodeBinaryTermMsg decodes the binary framed message, the input of BinaryInput is returned as it was
+// instead of TermMsg.Input
+func decodeBinaryTermMsg(data []byte) (msg TermMsg, input []byte, err error) {
+	if len(data) == 0 {
+		return msg, nil, fmt.Errorf("error: empty binary message")
+	}
+	payload := data[1:]
+	switch BinaryOpcode(data[0]) {
+	case BinaryInput:
+		msg.MsgType = TermInput
+		input = payload
+	case BinaryResize:
+		if len(payload) != 4 {
+			return msg, nil, fmt.Errorf("error: invalid binary resize payload length:%d", len(payload))
+		}
+		msg.MsgType = TermResize
+		msg.Cols = binary.BigEndian.Uint16(payload[:2])
+		msg.Rows = binary.BigEndian.Uint16(payload[2:])
+	case BinaryPing:
+		msg.MsgType = TermPing
+	case BinaryPause:
+		msg.MsgType = TermPause
+	case BinaryResume:
+		msg.MsgType = TermResume
+	case BinaryFilter:
+		msg.MsgType = TermFilter
+		if len(payload) > 0 {
+			msg.Filter = &LogFilterSpec{}
+			if err = json.Unmarshal(payload, msg.Filter); err != nil {
+				return msg, nil, err
+			}
+		}
+	default:
+		return msg, nil, fmt.Errorf(ErrBinaryOpcodeNotSupported, data[0])
+	}
+	return msg, input, nil
+}
+
+// EncodeBinaryTermMsg encodes the TermMsg by the binary framing for the clients of BinarySubprotocol,
+// the raw bytes of the stdin could be sent as `append([]byte{byte(BinaryInput)}, p...)` as well
+func EncodeBinaryTermMsg(msg *TermMsg) ([]byte, error) {
+	switch msg.MsgType {
+	case TermInput:
+		return append([]byte{byte(BinaryInput)}, msg.Input...), nil
+	case TermResize:
+		res := []byte{byte(BinaryResize), 0, 0, 0, 0}
+		binary.BigEndian.PutUint16(res[1:3], msg.Cols)
+		binary.BigEndian.PutUint16(res[3:], msg.Rows)
+		return res, nil
+	case TermPing:
+		return []byte{byte(BinaryPing)}, nil
+	case TermPause:
+		return []byte{byte(BinaryPause)}, nil
+	case TermResume:
+		return []byte{byte(BinaryResume)}, nil
+	case TermFilter:
+		if msg.Filter == nil {
+			return []byte{byte(BinaryFilter)}, nil
+		}
+		data, err := json.Marshal(msg.Filter)
+		if err != nil {
+			return nil, err
+		}
+		return append([]byte{byte(BinaryFilter)}, data...), nil
+	default:
+		return nil, fmt.Errorf("unknown message type '%s'", msg.MsgType)
+	}
+}
+
+// parseTermMsg parses the message of the client by the protocol of the proxy,
+// the input is returned as the bytes for both the protocols
+func parseTermMsg(binaryProtocol bool, wsMsg *message) (msg TermMsg, input []byte, err error) {
+	if binaryProtocol && wsMsg.messageType == websocket.BinaryMessage {
+		return decodeBinaryTermMsg(wsMsg.data)
+	}
+	if err = json.Unmarshal(wsMsg.data, &msg); err != nil {
+		return msg, nil, err
+	}
+	return msg, []byte(msg.Input), nil
+}
@@ -0,0 +1,94 @@
+package k8s_exec_pod
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/websocket"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestBinaryTermMsg(t *testing.T) {
+	for _, msg := range []TermMsg{
+		{MsgType: TermInput, Input: "ls -al\n"},
+		{MsgType: TermResize, Cols: 300, Rows: 54},
+		{MsgType: TermPing},
+		{MsgType: TermPause},
+		{MsgType: TermResume},
+		{MsgType: TermFilter, Filter: &LogFilterSpec{Include: "ERROR"}},
+		{MsgType: TermFilter},
+	} {
+		data, err := EncodeBinaryTermMsg(&msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, input, err := decodeBinaryTermMsg(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Input = string(input)
+		if !reflect.DeepEqual(res, msg) {
+			t.Fatalf("expected %+v, got %+v", msg, res)
+		}
+	}
+	// the raw bytes of the stdin are kept as they were
+	if _, input, err := decodeBinaryTermMsg([]byte{byte(BinaryInput), 0x18, 0xff, 0x00}); err != nil || fmt.Sprint(input) != "[24 255 0]" {
+		t.Fatalf("unexpected input %v err:%v", input, err)
+	}
+	for _, data := range [][]byte{nil, {byte(BinaryResize), 0, 80}, {0x7f}} {
+		if _, _, err := decodeBinaryTermMsg(data); err == nil {
+			t.Fatalf("expected an error for %v", data)
+		}
+	}
+}
+
+func TestBinarySubprotocol(t *testing.T) {
+	s, _ := newFakeServer(t, time.Millisecond*100)
+	defer s.ShutDown()
+	var res HttpResponse
+	getJSON(t, fmt.Sprintf("http://%s/cluster/%s/namespace/default/logs?selector=app%%3Dweb", s.Addr(), fakeClusterName), &res)
+	u := fmt.Sprintf("ws://%s/cluster/%s/log/sinceSeconds/0/sinceTime/0/token/%s", s.Addr(), fakeClusterName, res.Token)
+	dialer := websocket.Dialer{Subprotocols: []string{BinarySubprotocol}}
+	ws, _, err := dialer.Dial(u, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	if ws.Subprotocol() != BinarySubprotocol {
+		t.Fatalf("expected the subprotocol %s, got %q", BinarySubprotocol, ws.Subprotocol())
+	}
+	_ = ws.SetReadDeadline(time.Now().Add(time.Second * 5))
+
+	data, err := EncodeBinaryTermMsg(&TermMsg{MsgType: TermFilter, Filter: &LogFilterSpec{Include: "("}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = ws.WriteMessage(websocket.BinaryMessage, data); err != nil {
+		t.Fatal(err)
+	}
+	// the JSON TermMsg is still accepted as the text message
+	if err = ws.WriteJSON(TermMsg{MsgType: TermFilter}); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []ControlMessageType{ControlLogFilterFailed, ControlLogFilterApplied} {
+		_, data, err := ws.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var msg ControlMsg
+		if err = json.Unmarshal(data, &msg); err != nil || msg.MsgType != want {
+			t.Fatalf("expected %s, got %q err:%v", want, data, err)
+		}
+	}
+
+	// the JSON protocol is the default
+	ws2, _, err := websocket.DefaultDialer.Dial(fmt.Sprintf("ws://%s/cluster/%s/log/sinceSeconds/0/sinceTime/0/token/%s", s.Addr(), fakeClusterName, getToken(t, s)), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws2.Close()
+	if ws2.Subprotocol() != "" {
+		t.Fatalf("unexpected subprotocol %q", ws2.Subprotocol())
+	}
+}
@@ -0,0 +1,281 @@
+package k8s_exec_pod
+
+import (
+	"context"
+	"fmt"
+	"github.com/Shanghai-Lunara/pkg/zaplogger"
+	"io/ioutil"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	ErrClusterNotExist = "error: the cluster:%v was not exist"
+)
+
+// DefaultClusterName is used for the single cluster built from the in-cluster config or the master url
+const DefaultClusterName = "default"
+
+// clusterCheckTimeout bounds the reachability probe of every cluster
+const clusterCheckTimeout = time.Second * 3
+
+// Cluster keeps the client and the session accounting of one kubernetes cluster
+type Cluster struct {
+	name       string
+	cfg        *rest.Config
+	k8sClient  kubernetes.Interface
+	sessionHub SessionHub
+}
+
+// NewCluster returns a Cluster with its own SessionHub
+func NewCluster(name string, cfg *rest.Config, k8sClient kubernetes.Interface) *Cluster {
+	return &Cluster{
+		name:       name,
+		cfg:        cfg,
+		k8sClient:  k8sClient,
+		sessionHub: NewSessionHub(k8sClient, cfg),
+	}
+}
+
+func (c *Cluster) Name() string {
+	return c.name
+}
+
+func (c *Cluster) Config() *rest.Config {
+	return c.cfg
+}
+
+func (c *Cluster) Client() kubernetes.Interface {
+	return c.k8sClient
+}
+
+func (c *Cluster) SessionHub() SessionHub {
+	return c.sessionHub
+}
+
+// ClusterStatus is the reachability report of a Cluster
+type ClusterStatus struct {
+	Name      string `json:"name"`
+	Host      string `json:"host"`
+	Default   bool   `json:"default"`
+	Reachable bool   `json:"reachable"`
+	Version   string `json:"version,omitempty"`
+	Error     string `json:"error,omitempty"`
+	Sessions  int    `json:"sessions"`
+}
+
+// Status probes the apiserver version of the cluster
+func (c *Cluster) Status(ctx context.Context) ClusterStatus {
+	status := ClusterStatus{
+		Name:     c.name,
+		Sessions: len(c.sessionHub.List()),
+	}
+	if c.cfg != nil {
+		status.Host = c.cfg.Host
+	}
+	type result struct {
+		version string
+		err     error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		v, err := c.k8sClient.Discovery().ServerVersion()
+		if err != nil {
+			ch <- result{err: err}
+			return
+		}
+		ch <- result{version: v.GitVersion}
+	}()
+	select {
+	case r := <-ch:
+		if r.err != nil {
+			status.Error = r.err.Error()
+		} else {
+			status.Reachable = true
+			status.Version = r.version
+		}
+	case <-ctx.Done():
+		status.Error = ctx.Err().Error()
+	}
+	return status
+}
+
+type ClusterHub interface {
+	// Get returns the cluster with the name, the default one would be returned if the name was empty
+	Get(name string) (*Cluster, error)
+	List() []*Cluster
+	Status(ctx context.Context) []ClusterStatus
+}
+
+// NewClusterHub returns a ClusterHub, the first cluster would be the default one if defaultName was empty
+func NewClusterHub(defaultName string, clusters ...*Cluster) (ClusterHub, error) {
+	if len(clusters) == 0 {
+		return nil, fmt.Errorf("error: no cluster was configured")
+	}
+	ch := &clusterHub{
+		items: make(map[string]*Cluster, len(clusters)),
+	}
+	for _, c := range clusters {
+		if _, ok := ch.items[c.Name()]; ok {
+			return nil, fmt.Errorf("error: the cluster:%v was duplicated", c.Name())
+		}
+		ch.items[c.Name()] = c
+		ch.names = append(ch.names, c.Name())
+	}
+	if defaultName == "" {
+		defaultName = clusters[0].Name()
+	}
+	if _, ok := ch.items[defaultName]; !ok {
+		return nil, fmt.Errorf(ErrClusterNotExist, defaultName)
+	}
+	ch.defaultName = defaultName
+	sort.Strings(ch.names)
+	return ch, nil
+}
+
+type clusterHub struct {
+	items       map[string]*Cluster
+	names       []string
+	defaultName string
+}
+
+func (ch *clusterHub) Get(name string) (*Cluster, error) {
+	if name == "" {
+		name = ch.defaultName
+	}
+	if c, ok := ch.items[name]; ok {
+		return c, nil
+	}
+	return nil, fmt.Errorf(ErrClusterNotExist, name)
+}
+
+func (ch *clusterHub) List() []*Cluster {
+	res := make([]*Cluster, 0, len(ch.names))
+	for _, name := range ch.names {
+		res = append(res, ch.items[name])
+	}
+	return res
+}
+
+func (ch *clusterHub) Status(ctx context.Context) []ClusterStatus {
+	ctx, cancel := context.WithTimeout(ctx, clusterCheckTimeout)
+	defer cancel()
+	clusters := ch.List()
+	res := make([]ClusterStatus, len(clusters))
+	var wg sync.WaitGroup
+	for i, c := range clusters {
+		wg.Add(1)
+		go func(i int, c *Cluster) {
+			defer wg.Done()
+			res[i] = c.Status(ctx)
+			res[i].Default = c.Name() == ch.defaultName
+		}(i, c)
+	}
+	wg.Wait()
+	return res
+}
+
+// LoadClusters builds the clusters from the kubeconfig contexts.
+// Every context of the kubeconfig file and of the files inside kubeconfigDir would be loaded as a cluster
+// named by the context name, unless contexts were given. The files are loaded in the order of the kubeconfig file
+// and then the sorted files inside kubeconfigDir, a context name which was already loaded from a former file would
+// be named `<context>@<file name>` instead, and skipped if that name was loaded as well. The masterUrl overrides the server of the contexts of the kubeconfig
+// file as clientcmd.BuildConfigFromFlags does. Without any kubeconfig, the in-cluster config or the masterUrl
+// would be used for the DefaultClusterName cluster.
+func LoadClusters(masterUrl, kubeconfig, kubeconfigDir string, contexts []string, defaultName string) (ClusterHub, error) {
+	files := make([]string, 0)
+	if kubeconfig != "" {
+		files = append(files, kubeconfig)
+	}
+	if kubeconfigDir != "" {
+		entries, err := ioutil.ReadDir(kubeconfigDir)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			if e.IsDir() || strings.HasPrefix(e.Name(), ".") {
+				continue
+			}
+			file := filepath.Join(kubeconfigDir, e.Name())
+			if sameFile(file, kubeconfig) {
+				continue
+			}
+			files = append(files, file)
+		}
+	}
+	if len(files) == 0 {
+		cfg, k8sClient := NewResource(masterUrl, "")
+		return NewClusterHub(defaultName, NewCluster(DefaultClusterName, cfg, k8sClient))
+	}
+	currentContext := ""
+	wanted := make(map[string]bool, len(contexts))
+	for _, v := range contexts {
+		wanted[v] = true
+	}
+	clusters := make([]*Cluster, 0)
+	loaded := make(map[string]bool)
+	for _, file := range files {
+		raw, err := clientcmd.LoadFromFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("error: load kubeconfig:%s err:%v", file, err)
+		}
+		if file == kubeconfig {
+			currentContext = raw.CurrentContext
+		}
+		names := make([]string, 0, len(raw.Contexts))
+		for name := range raw.Contexts {
+			if len(wanted) > 0 && !wanted[name] {
+				continue
+			}
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		overrides := &clientcmd.ConfigOverrides{}
+		if file == kubeconfig && masterUrl != "" {
+			overrides.ClusterInfo.Server = masterUrl
+		}
+		for _, name := range names {
+			cfg, err := clientcmd.NewNonInteractiveClientConfig(*raw, name, overrides, nil).ClientConfig()
+			if err != nil {
+				return nil, fmt.Errorf("error: build kubeconfig:%s context:%s err:%v", file, name, err)
+			}
+			k8sClient, err := kubernetes.NewForConfig(cfg)
+			if err != nil {
+				return nil, fmt.Errorf("error: build kubernetes clientset context:%s err:%v", name, err)
+			}
+			clusterName := name
+			if loaded[clusterName] {
+				clusterName = name + "@" + filepath.Base(file)
+				zaplogger.Sugar().Warnw("LoadClusters duplicated context", "kubeconfig", file, "context", name, "cluster", clusterName)
+			}
+			if loaded[clusterName] {
+				zaplogger.Sugar().Warnw("LoadClusters skip the duplicated context", "kubeconfig", file, "context", name)
+				continue
+			}
+			loaded[clusterName] = true
+			zaplogger.Sugar().Infow("LoadClusters", "kubeconfig", file, "context", name, "cluster", clusterName, "host", cfg.Host)
+			clusters = append(clusters, NewCluster(clusterName, cfg, k8sClient))
+		}
+	}
+	// fall back to the current-context of the kubeconfig file if it was loaded
+	if defaultName == "" && (len(wanted) == 0 || wanted[currentContext]) {
+		defaultName = currentContext
+	}
+	return NewClusterHub(defaultName, clusters...)
+}
+
+// sameFile reports whether the paths were the same file, e.g. the kubeconfig file was inside the kubeconfigDir
+func sameFile(a, b string) bool {
+	if a == "" || b == "" {
+		return false
+	}
+	absA, errA := filepath.Abs(a)
+	absB, errB := filepath.Abs(b)
+	return errA == nil && errB == nil && absA == absB
+}
@@ -0,0 +1,105 @@
+package k8s_exec_pod
+
+import (
+	"fmt"
+	"io/ioutil"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeKubeconfig writes a kubeconfig of the contexts, every context has its own cluster of the server
+func writeKubeconfig(t *testing.T, file, current string, contexts map[string]string) {
+	cfg := clientcmdapi.NewConfig()
+	cfg.AuthInfos["user"] = &clientcmdapi.AuthInfo{Token: "token"}
+	for name, server := range contexts {
+		cfg.Clusters[name] = &clientcmdapi.Cluster{Server: server}
+		cfg.Contexts[name] = &clientcmdapi.Context{Cluster: name, AuthInfo: "user"}
+	}
+	cfg.CurrentContext = current
+	if err := clientcmd.WriteToFile(*cfg, file); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func clusterHosts(hub ClusterHub) string {
+	res := make([]string, 0)
+	for _, c := range hub.List() {
+		res = append(res, c.Name()+"="+c.Config().Host)
+	}
+	return fmt.Sprint(res)
+}
+
+func TestLoadClusters(t *testing.T) {
+	dir, err := ioutil.TempDir("", "k8s-exec-pod-cluster-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	kubeconfig := filepath.Join(dir, "config")
+	writeKubeconfig(t, kubeconfig, "dev", map[string]string{"dev": "https://dev:6443", "prod": "https://prod:6443"})
+	configDir := filepath.Join(dir, "configs")
+	if err = os.Mkdir(configDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeKubeconfig(t, filepath.Join(configDir, "a.yaml"), "", map[string]string{"dev": "https://a-dev:6443", "qa": "https://qa:6443"})
+	writeKubeconfig(t, filepath.Join(configDir, "b.yaml"), "", map[string]string{"qa": "https://b-qa:6443"})
+
+	hub, err := LoadClusters("", kubeconfig, configDir, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "[dev=https://dev:6443 dev@a.yaml=https://a-dev:6443 prod=https://prod:6443 qa=https://qa:6443 qa@b.yaml=https://b-qa:6443]"
+	if res := clusterHosts(hub); res != expected {
+		t.Fatalf("expected %s, got %s", expected, res)
+	}
+	if c, err := hub.Get(""); err != nil || c.Name() != "dev" {
+		t.Fatalf("expected the current-context to be the default cluster, got %v err:%v", c, err)
+	}
+
+	// the master url overrides the server of the kubeconfig file only
+	hub, err = LoadClusters("https://master:6443", kubeconfig, configDir, []string{"prod", "qa"}, "qa")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = "[prod=https://master:6443 qa=https://qa:6443 qa@b.yaml=https://b-qa:6443]"
+	if res := clusterHosts(hub); res != expected {
+		t.Fatalf("expected %s, got %s", expected, res)
+	}
+	if c, err := hub.Get(""); err != nil || c.Name() != "qa" {
+		t.Fatalf("expected qa to be the default cluster, got %v err:%v", c, err)
+	}
+
+	// the kubeconfig file inside the kubeconfigDir is loaded once
+	if _, err = LoadClusters("", filepath.Join(configDir, "a.yaml"), configDir, nil, "dev"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNewClusterHub(t *testing.T) {
+	if _, err := NewClusterHub(""); err == nil {
+		t.Fatal("expected an error without any cluster")
+	}
+	a := NewCluster("a", &rest.Config{}, fake.NewSimpleClientset())
+	b := NewCluster("b", &rest.Config{}, fake.NewSimpleClientset())
+	if _, err := NewClusterHub("", a, NewCluster("a", &rest.Config{}, fake.NewSimpleClientset())); err == nil {
+		t.Fatal("expected an error for the duplicated clusters")
+	}
+	if _, err := NewClusterHub("c", a, b); err == nil {
+		t.Fatal("expected an error for the unknown default cluster")
+	}
+	hub, err := NewClusterHub("b", a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c, err := hub.Get(""); err != nil || c != b {
+		t.Fatalf("expected the default cluster b, got %v err:%v", c, err)
+	}
+	if _, err = hub.Get("c"); err == nil {
+		t.Fatal("expected an error for the unknown cluster")
+	}
+}
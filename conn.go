@@ -3,9 +3,11 @@ package k8s_exec_pod
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"github.com/Shanghai-Lunara/pkg/zaplogger"
 	"github.com/gorilla/websocket"
+	"io"
 	"net/http"
 	"sync"
 	"time"
@@ -17,34 +19,75 @@ var upGrader = websocket.Upgrader{
 	CheckOrigin: func(r *http.Request) bool {
 		return true
 	},
+	// the JSON protocol is used unless the client requested BinarySubprotocol
+	Subprotocols: []string{BinarySubprotocol},
 }
 
 type Proxy interface {
 	ReadPump()
 	WritePump()
 	Close()
+	CloseWithReason(code int, reason string)
 	Recv() (*message, error)
 	KeepAlive()
 	HandlePing()
 	Send(messageType int, data []byte) error
 	LoadBuffers(buf []byte) (n int, err error)
 	HandleInput(buf []byte, appendBuf []byte) (n int, err error)
+	// SendTimeout is Send which gives up after the timeout, or at once if the timeout was not positive
+	SendTimeout(messageType int, data []byte, timeout time.Duration) error
+	// Binary reports whether BinarySubprotocol was negotiated at the upgrade
+	Binary() bool
 }
 
+// ProxyOptions configures the websocket of NewProxyWithOptions
+type ProxyOptions struct {
+	// CompressionLevel negotiates the permessage-deflate extension by the flate level, 0 disables the compression
+	CompressionLevel int
+	// WriteTimeout is the write deadline of every message, DefaultWriteTimeout if it was not positive
+	WriteTimeout time.Duration
+}
+
+// DefaultWriteTimeout is the write deadline of the websocket messages if ProxyOptions.WriteTimeout was not positive
+const DefaultWriteTimeout = time.Second * 10
+
+// errSendTimeout is returned by SendTimeout once the message was not queued within the timeout
+var errSendTimeout = errors.New("error: the send was timed out")
+
 func NewProxy(ctx context.Context, w http.ResponseWriter, r *http.Request) (Proxy, error) {
-	conn, err := upGrader.Upgrade(w, r, nil)
+	return NewProxyWithOptions(ctx, w, r, &ProxyOptions{})
+}
+
+// NewProxyWithOptions upgrades the request to the websocket by the options
+func NewProxyWithOptions(ctx context.Context, w http.ResponseWriter, r *http.Request, opts *ProxyOptions) (Proxy, error) {
+	u := upGrader
+	u.EnableCompression = opts.CompressionLevel != 0
+	conn, err := u.Upgrade(w, r, nil)
 	if err != nil {
 		zaplogger.Sugar().Error(err)
 		return nil, err
 	}
+	if u.EnableCompression {
+		// the level was validated by InitServer, the compression is only used if the client negotiated it
+		if err = conn.SetCompressionLevel(opts.CompressionLevel); err != nil {
+			zaplogger.Sugar().Error(err)
+		}
+	}
+	writeTimeout := opts.WriteTimeout
+	if writeTimeout <= 0 {
+		writeTimeout = DefaultWriteTimeout
+	}
 	subCtx, cancel := context.WithCancel(ctx)
 	p := &proxy{
-		conn:             conn,
-		status:           proxyAlive,
-		readChan:         make(chan *message, 4096),
-		writeChan:        make(chan *message, 4096),
+		conn:     conn,
+		status:   proxyAlive,
+		readChan: make(chan *message, 4096),
+		// the output was coalesced into the frames, a short queue detects a slow client sooner
+		writeChan:        make(chan *message, 256),
 		lastPingTime:     time.Now(),
 		keepAliveTimeout: 10,
+		binary:           conn.Subprotocol() == BinarySubprotocol,
+		writeTimeout:     writeTimeout,
 		ctx:              subCtx,
 		cancel:           cancel,
 	}
@@ -70,9 +113,15 @@ type proxy struct {
 
 	lastPingTime     time.Time
 	keepAliveTimeout int64
+	binary           bool
+	writeTimeout     time.Duration
 	closeOnce        sync.Once
 	ctx              context.Context
 	cancel           context.CancelFunc
+
+	// readErr is the error which stopped the ReadPump, e.g. the close frame of the client
+	readErr   error
+	readErrMu sync.Mutex
 }
 
 type message struct {
@@ -106,7 +155,14 @@ func (p *proxy) ReadPump() {
 		//zaplogger.Sugar().Info("data:", data)
 		//zaplogger.Sugar().Infof("messageType: %d message: %v err: %s\n", messageType, data, err)
 		if err != nil {
-			zaplogger.Sugar().Error(err)
+			if isNormalClose(err) {
+				zaplogger.Sugar().Info(err)
+			} else {
+				zaplogger.Sugar().Error(err)
+			}
+			p.readErrMu.Lock()
+			p.readErr = err
+			p.readErrMu.Unlock()
 			return
 		}
 		msg := &message{messageType: messageType, data: data}
@@ -131,10 +187,18 @@ func (p *proxy) WritePump() {
 				return
 			}
 			//zaplogger.Sugar().Info("proxy WritePump msg-data:", string(msg.data))
+			// a stuck client fails the write instead of blocking the senders forever
+			if err := p.conn.SetWriteDeadline(time.Now().Add(p.writeTimeout)); err != nil {
+				zaplogger.Sugar().Error(err)
+				return
+			}
 			if err := p.conn.WriteMessage(msg.messageType, msg.data); err != nil {
 				zaplogger.Sugar().Error(err)
 				return
 			}
+			if msg.messageType == websocket.CloseMessage {
+				return
+			}
 		case <-p.ctx.Done():
 			return
 		}
@@ -157,6 +221,34 @@ func (p *proxy) Close() {
 	})
 }
 
+// maxCloseReasonLength is the limit of the close frame payload without the 2 bytes close code
+const maxCloseReasonLength = 123
+
+// closeFlushTimeout bounds the wait of flushing the pending messages before the close frame
+const closeFlushTimeout = time.Second
+
+// CloseWithReason sends a close frame with the code and the reason after the pending messages were flushed,
+// then closes the connection
+func (p *proxy) CloseWithReason(code int, reason string) {
+	if p.ctx.Err() == nil {
+		if len(reason) > maxCloseReasonLength {
+			reason = reason[:maxCloseReasonLength]
+		}
+		msg := &message{messageType: websocket.CloseMessage, data: websocket.FormatCloseMessage(code, reason)}
+		select {
+		case p.writeChan <- msg:
+			// WritePump closes the proxy once the close frame was written
+			select {
+			case <-p.ctx.Done():
+			case <-time.After(closeFlushTimeout):
+			}
+		case <-p.ctx.Done():
+		case <-time.After(closeFlushTimeout):
+		}
+	}
+	p.Close()
+}
+
 func (p *proxy) Recv() (*message, error) {
 	//zaplogger.Sugar().Info("proxy Recv message")
 	select {
@@ -168,13 +260,24 @@ func (p *proxy) Recv() (*message, error) {
 		//zaplogger.Sugar().Info("proxy recv-data-string:", string(msg.data))
 		return msg, nil
 	case <-p.ctx.Done():
+		p.readErrMu.Lock()
+		defer p.readErrMu.Unlock()
+		if p.readErr != nil {
+			// tells the close of the client from the other closes
+			return nil, p.readErr
+		}
 		return nil, fmt.Errorf("proxy ctx cancel")
 	}
 }
 
+// isNormalClose reports whether the err was a normal close of the client, i.e. a websocket.CloseNormalClosure or io.EOF
+func isNormalClose(err error) bool {
+	return err == io.EOF || websocket.IsCloseError(err, websocket.CloseNormalClosure)
+}
+
 func (p *proxy) Send(messageType int, data []byte) error {
 	//zaplogger.Sugar().Infof("proxy send messageType:%v data:%v", messageType, string(data))
-	if p.status == proxyClose {
+	if p.ctx.Err() != nil {
 		return fmt.Errorf("err: proxy has been closed")
 	}
 	select {
@@ -185,6 +288,33 @@ func (p *proxy) Send(messageType int, data []byte) error {
 	}
 }
 
+func (p *proxy) SendTimeout(messageType int, data []byte, timeout time.Duration) error {
+	if p.ctx.Err() != nil {
+		return fmt.Errorf("err: proxy has been closed")
+	}
+	msg := &message{messageType: messageType, data: data}
+	if timeout <= 0 {
+		select {
+		case p.writeChan <- msg:
+			return nil
+		case <-p.ctx.Done():
+			return fmt.Errorf("proxy ctx cancel")
+		default:
+			return errSendTimeout
+		}
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case p.writeChan <- msg:
+		return nil
+	case <-p.ctx.Done():
+		return fmt.Errorf("proxy ctx cancel")
+	case <-timer.C:
+		return errSendTimeout
+	}
+}
+
 func (p *proxy) LoadBuffers(buf []byte) (n int, err error) {
 	if p.inputBuffers.Len() > 0 {
 		n = copy(buf, p.inputBuffers.Bytes())
@@ -197,3 +327,7 @@ func (p *proxy) HandleInput(buf []byte, appendBuf []byte) (n int, err error) {
 	p.inputBuffers.Write(appendBuf)
 	return p.LoadBuffers(buf)
 }
+
+func (p *proxy) Binary() bool {
+	return p.binary
+}
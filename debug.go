@@ -0,0 +1,76 @@
+package k8s_exec_pod
+
+import (
+	"context"
+	"fmt"
+	"github.com/Shanghai-Lunara/pkg/zaplogger"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilrand "k8s.io/apimachinery/pkg/util/rand"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"time"
+)
+
+// debugContainerPrefix prefixes the name of the ephemeral debug containers, like `kubectl debug` does
+const debugContainerPrefix = "debugger-"
+
+// debugPollInterval is the interval of checking whether the debug container was running
+const debugPollInterval = time.Second
+
+// CreateDebugContainer injects an ephemeral container with the image into the pod through the
+// pods/ephemeralcontainers subresource, sharing the process namespace of the target container.
+// It waits up to timeout for the debug container to be running and returns its name.
+func CreateDebugContainer(k8sClient kubernetes.Interface, namespace, podName, targetContainer, image string, timeout time.Duration) (string, error) {
+	ctx := context.Background()
+	pod, err := k8sClient.CoreV1().Pods(namespace).Get(ctx, podName, metav1.GetOptions{})
+	if err != nil {
+		return "", err
+	}
+	found := false
+	for _, c := range pod.Spec.Containers {
+		if c.Name == targetContainer {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return "", fmt.Errorf("error: the container:%s was not exist in pod:%s", targetContainer, podName)
+	}
+	name := debugContainerPrefix + utilrand.String(5)
+	pod.Spec.EphemeralContainers = append(pod.Spec.EphemeralContainers, corev1.EphemeralContainer{
+		EphemeralContainerCommon: corev1.EphemeralContainerCommon{
+			Name:                     name,
+			Image:                    image,
+			ImagePullPolicy:          corev1.PullIfNotPresent,
+			Stdin:                    true,
+			TTY:                      true,
+			TerminationMessagePolicy: corev1.TerminationMessageFallbackToLogsOnError,
+		},
+		TargetContainerName: targetContainer,
+	})
+	if _, err = k8sClient.CoreV1().Pods(namespace).UpdateEphemeralContainers(ctx, podName, pod, metav1.UpdateOptions{}); err != nil {
+		return "", err
+	}
+	zaplogger.Sugar().Infow("CreateDebugContainer", "namespace", namespace, "pod", podName, "target", targetContainer, "image", image, "container", name)
+	err = wait.PollImmediate(debugPollInterval, timeout, func() (bool, error) {
+		pod, err := k8sClient.CoreV1().Pods(namespace).Get(ctx, podName, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		for _, status := range pod.Status.EphemeralContainerStatuses {
+			if status.Name != name {
+				continue
+			}
+			if status.State.Terminated != nil {
+				return false, fmt.Errorf("error: the debug container:%s was terminated reason:%s", name, status.State.Terminated.Reason)
+			}
+			return status.State.Running != nil, nil
+		}
+		return false, nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("error: wait for the debug container:%s err:%v", name, err)
+	}
+	return name, nil
+}
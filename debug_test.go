@@ -0,0 +1,157 @@
+package k8s_exec_pod
+
+import (
+	"fmt"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newDebugTargetPod() *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "pod-0", Namespace: "default"},
+		Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}},
+	}
+}
+
+// reactEphemeralContainers records the pods submitted to the ephemeralcontainers subresource, the debug containers
+// would be reported as the state of the reactor returned
+func reactEphemeralContainers(k8sClient *fake.Clientset, state func(name string) corev1.ContainerState) *[]*corev1.Pod {
+	var submitted []*corev1.Pod
+	k8sClient.PrependReactor("update", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		update := action.(k8stesting.UpdateAction)
+		if update.GetSubresource() != "ephemeralcontainers" {
+			return false, nil, nil
+		}
+		pod := update.GetObject().(*corev1.Pod).DeepCopy()
+		submitted = append(submitted, pod.DeepCopy())
+		pod.Status.EphemeralContainerStatuses = nil
+		for _, c := range pod.Spec.EphemeralContainers {
+			pod.Status.EphemeralContainerStatuses = append(pod.Status.EphemeralContainerStatuses, corev1.ContainerStatus{
+				Name:  c.Name,
+				State: state(c.Name),
+			})
+		}
+		if err := k8sClient.Tracker().Update(corev1.SchemeGroupVersion.WithResource("pods"), pod, pod.Namespace); err != nil {
+			return true, nil, err
+		}
+		return true, pod, nil
+	})
+	return &submitted
+}
+
+func running(string) corev1.ContainerState {
+	return corev1.ContainerState{Running: &corev1.ContainerStateRunning{}}
+}
+
+func TestPolicyDebugImage(t *testing.T) {
+	policy := &Policy{DebugImages: []string{"busybox:1.36", "nicolaka/netshoot"}}
+	for _, v := range []struct {
+		policy *Policy
+		image  string
+		expect string
+		err    bool
+	}{
+		{policy: policy, image: "", expect: "busybox:1.36"},
+		{policy: policy, image: "nicolaka/netshoot", expect: "nicolaka/netshoot"},
+		{policy: policy, image: "alpine", err: true},
+		{policy: policy, image: "busybox", err: true},
+		{policy: &Policy{}, image: "", err: true},
+		{policy: nil, image: "busybox:1.36", err: true},
+	} {
+		image, err := v.policy.DebugImage(v.image)
+		if v.err {
+			if err == nil || err.Error() != fmt.Sprintf(ErrDebugImageNotAllowed, v.image) {
+				t.Fatalf("expected the image:%q was not allowed, got image:%q err:%v", v.image, image, err)
+			}
+			continue
+		}
+		if err != nil || image != v.expect {
+			t.Fatalf("unexpected image:%q err:%v for %q, expected %q", image, err, v.image, v.expect)
+		}
+	}
+}
+
+func TestCreateDebugContainer(t *testing.T) {
+	k8sClient := fake.NewSimpleClientset(newDebugTargetPod())
+	submitted := reactEphemeralContainers(k8sClient, running)
+
+	name, err := CreateDebugContainer(k8sClient, "default", "pod-0", "app", "busybox:1.36", time.Second*3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(name, debugContainerPrefix) {
+		t.Fatalf("unexpected debug container name:%s", name)
+	}
+	if len(*submitted) != 1 {
+		t.Fatalf("expected one ephemeral containers update, got %d", len(*submitted))
+	}
+	containers := (*submitted)[0].Spec.EphemeralContainers
+	if len(containers) != 1 {
+		t.Fatalf("unexpected ephemeral containers: %+v", containers)
+	}
+	c := containers[0]
+	if c.Name != name || c.Image != "busybox:1.36" || c.TargetContainerName != "app" {
+		t.Fatalf("unexpected ephemeral container: %+v", c)
+	}
+	if !c.Stdin || !c.TTY || c.ImagePullPolicy != corev1.PullIfNotPresent {
+		t.Fatalf("expected an interactive ephemeral container: %+v", c)
+	}
+}
+
+func TestCreateDebugContainerFailed(t *testing.T) {
+	k8sClient := fake.NewSimpleClientset(newDebugTargetPod())
+	submitted := reactEphemeralContainers(k8sClient, func(string) corev1.ContainerState {
+		return corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{Reason: "StartError"}}
+	})
+
+	if _, err := CreateDebugContainer(k8sClient, "default", "pod-0", "sidecar", "busybox:1.36", time.Second); err == nil {
+		t.Fatal("expected the missing target container was rejected")
+	}
+	if len(*submitted) != 0 {
+		t.Fatalf("expected no ephemeral container was submitted for a missing target, got %d", len(*submitted))
+	}
+	_, err := CreateDebugContainer(k8sClient, "default", "pod-0", "app", "busybox:1.36", time.Second)
+	if err == nil || !strings.Contains(err.Error(), "StartError") {
+		t.Fatalf("expected the terminated debug container was reported, got err:%v", err)
+	}
+}
+
+func TestPodTokenDebugImage(t *testing.T) {
+	s, _ := newFakeServerWithOptions(t, &ServerOptions{
+		DrainTimeout: time.Millisecond * 100,
+		DebugTimeout: time.Second * 3,
+		Policy:       &Policy{DebugImages: []string{"busybox:1.36"}},
+	}, newDebugTargetPod())
+	defer s.ShutDown()
+	cluster, err := s.clusters.Get(fakeClusterName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	submitted := reactEphemeralContainers(cluster.Client().(*fake.Clientset), running)
+	u := fmt.Sprintf("http://%s/namespace/default/pod/pod-0/shell/app/sh", s.Addr())
+
+	// the image which was not allowed must be rejected before touching the pod
+	var res HttpResponse
+	getJSON(t, u+"?debugImage=alpine", &res)
+	if res.Code != CodeError || res.Message != fmt.Sprintf(ErrDebugImageNotAllowed, "alpine") {
+		t.Fatalf("unexpected response for a denied image: %+v", res)
+	}
+	if len(*submitted) != 0 {
+		t.Fatalf("expected no ephemeral container was submitted, got %d", len(*submitted))
+	}
+
+	res = HttpResponse{}
+	getJSON(t, u+"?debug=true", &res)
+	if res.Code != CodeSuccess || !strings.HasPrefix(res.Container, debugContainerPrefix) {
+		t.Fatalf("unexpected response for the default image: %+v", res)
+	}
+	if len(*submitted) != 1 || (*submitted)[0].Spec.EphemeralContainers[0].Image != "busybox:1.36" {
+		t.Fatalf("expected the default image was submitted: %+v", *submitted)
+	}
+}
@@ -0,0 +1,164 @@
+package k8s_exec_pod
+
+import (
+	"context"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"sort"
+	"strings"
+)
+
+type ContainerType string
+
+const (
+	ContainerTypeInit      ContainerType = "init"
+	ContainerTypeRegular   ContainerType = "container"
+	ContainerTypeEphemeral ContainerType = "ephemeral"
+)
+
+type ContainerState string
+
+const (
+	ContainerStateWaiting    ContainerState = "waiting"
+	ContainerStateRunning    ContainerState = "running"
+	ContainerStateTerminated ContainerState = "terminated"
+	ContainerStateUnknown    ContainerState = "unknown"
+)
+
+// ContainerInfo is the summary of a container for building the terminal UIs
+type ContainerInfo struct {
+	Name         string         `json:"name"`
+	Image        string         `json:"image"`
+	Type         ContainerType  `json:"type"`
+	State        ContainerState `json:"state"`
+	Reason       string         `json:"reason,omitempty"`
+	Ready        bool           `json:"ready"`
+	RestartCount int32          `json:"restartCount"`
+}
+
+// PodInfo is the summary of a pod for building the terminal UIs
+type PodInfo struct {
+	Name       string            `json:"name"`
+	Namespace  string            `json:"namespace"`
+	Phase      corev1.PodPhase   `json:"phase"`
+	Ready      bool              `json:"ready"`
+	NodeName   string            `json:"nodeName"`
+	PodIP      string            `json:"podIP"`
+	Labels     map[string]string `json:"labels,omitempty"`
+	CreateTime metav1.Time       `json:"createTime"`
+	Containers []ContainerInfo   `json:"containers"`
+}
+
+// ListNamespaces returns the names of the namespaces which were allowed by the policy
+func ListNamespaces(k8sClient kubernetes.Interface, policy *Policy) ([]string, error) {
+	list, err := k8sClient.CoreV1().Namespaces().List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	res := make([]string, 0, len(list.Items))
+	for _, v := range list.Items {
+		if policy.CheckNamespace(v.Name) != nil {
+			continue
+		}
+		res = append(res, v.Name)
+	}
+	sort.Strings(res)
+	return res, nil
+}
+
+// ListPods returns the pods matching the labelSelector, phases is a comma separated list of corev1.PodPhase
+// and all phases would be matched if it was empty
+func ListPods(k8sClient kubernetes.Interface, namespace, labelSelector, phases string) ([]PodInfo, error) {
+	wanted := make(map[string]bool)
+	for _, v := range strings.Split(phases, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			wanted[strings.ToLower(v)] = true
+		}
+	}
+	list, err := k8sClient.CoreV1().Pods(namespace).List(context.Background(), metav1.ListOptions{LabelSelector: labelSelector})
+	if err != nil {
+		return nil, err
+	}
+	res := make([]PodInfo, 0, len(list.Items))
+	for i := range list.Items {
+		pod := &list.Items[i]
+		if len(wanted) > 0 && !wanted[strings.ToLower(string(pod.Status.Phase))] {
+			continue
+		}
+		res = append(res, newPodInfo(pod))
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Name < res[j].Name
+	})
+	return res, nil
+}
+
+// ListContainers returns the init, regular and ephemeral containers of the pod
+func ListContainers(k8sClient kubernetes.Interface, namespace, podName string) ([]ContainerInfo, error) {
+	pod, err := k8sClient.CoreV1().Pods(namespace).Get(context.Background(), podName, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return newPodInfo(pod).Containers, nil
+}
+
+func newPodInfo(pod *corev1.Pod) PodInfo {
+	info := PodInfo{
+		Name:       pod.Name,
+		Namespace:  pod.Namespace,
+		Phase:      pod.Status.Phase,
+		Ready:      isPodReady(pod),
+		NodeName:   pod.Spec.NodeName,
+		PodIP:      pod.Status.PodIP,
+		Labels:     pod.Labels,
+		CreateTime: pod.CreationTimestamp,
+		Containers: make([]ContainerInfo, 0),
+	}
+	for _, c := range pod.Spec.InitContainers {
+		info.Containers = append(info.Containers, newContainerInfo(c.Name, c.Image, ContainerTypeInit, pod.Status.InitContainerStatuses))
+	}
+	for _, c := range pod.Spec.Containers {
+		info.Containers = append(info.Containers, newContainerInfo(c.Name, c.Image, ContainerTypeRegular, pod.Status.ContainerStatuses))
+	}
+	for _, c := range pod.Spec.EphemeralContainers {
+		info.Containers = append(info.Containers, newContainerInfo(c.Name, c.Image, ContainerTypeEphemeral, pod.Status.EphemeralContainerStatuses))
+	}
+	return info
+}
+
+func newContainerInfo(name, image string, t ContainerType, statuses []corev1.ContainerStatus) ContainerInfo {
+	info := ContainerInfo{
+		Name:  name,
+		Image: image,
+		Type:  t,
+		State: ContainerStateUnknown,
+	}
+	for _, status := range statuses {
+		if status.Name != name {
+			continue
+		}
+		info.Ready = status.Ready
+		info.RestartCount = status.RestartCount
+		switch {
+		case status.State.Running != nil:
+			info.State = ContainerStateRunning
+		case status.State.Waiting != nil:
+			info.State = ContainerStateWaiting
+			info.Reason = status.State.Waiting.Reason
+		case status.State.Terminated != nil:
+			info.State = ContainerStateTerminated
+			info.Reason = status.State.Terminated.Reason
+		}
+	}
+	return info
+}
+
+func isPodReady(pod *corev1.Pod) bool {
+	for _, c := range pod.Status.Conditions {
+		if c.Type == corev1.PodReady {
+			return c.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
@@ -0,0 +1,70 @@
+package k8s_exec_pod
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"testing"
+)
+
+func TestListPodsAndContainers(t *testing.T) {
+	k8sClient := fake.NewSimpleClientset(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "kube-system"}},
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "app-0", Namespace: "default", Labels: map[string]string{"app": "web"}},
+			Spec: corev1.PodSpec{
+				InitContainers:      []corev1.Container{{Name: "init", Image: "busybox"}},
+				Containers:          []corev1.Container{{Name: "web", Image: "nginx"}},
+				EphemeralContainers: []corev1.EphemeralContainer{{EphemeralContainerCommon: corev1.EphemeralContainerCommon{Name: "debugger", Image: "busybox"}}},
+			},
+			Status: corev1.PodStatus{
+				Phase:                 corev1.PodRunning,
+				InitContainerStatuses: []corev1.ContainerStatus{{Name: "init", State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{Reason: "Completed"}}}},
+				ContainerStatuses:     []corev1.ContainerStatus{{Name: "web", Ready: true, State: corev1.ContainerState{Running: &corev1.ContainerStateRunning{}}}},
+			},
+		},
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "app-1", Namespace: "default", Labels: map[string]string{"app": "web"}},
+			Status:     corev1.PodStatus{Phase: corev1.PodPending},
+		},
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "db-0", Namespace: "default", Labels: map[string]string{"app": "db"}},
+			Status:     corev1.PodStatus{Phase: corev1.PodRunning},
+		},
+	)
+
+	namespaces, err := ListNamespaces(k8sClient, &Policy{DeniedNamespaces: []string{"kube-system"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(namespaces) != 1 || namespaces[0] != "default" {
+		t.Fatalf("unexpected namespaces: %v", namespaces)
+	}
+
+	pods, err := ListPods(k8sClient, "default", "app=web", "Running")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pods) != 1 || pods[0].Name != "app-0" {
+		t.Fatalf("unexpected pods: %+v", pods)
+	}
+
+	containers, err := ListContainers(k8sClient, "default", "app-0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []ContainerInfo{
+		{Name: "init", Image: "busybox", Type: ContainerTypeInit, State: ContainerStateTerminated, Reason: "Completed"},
+		{Name: "web", Image: "nginx", Type: ContainerTypeRegular, State: ContainerStateRunning, Ready: true},
+		{Name: "debugger", Image: "busybox", Type: ContainerTypeEphemeral, State: ContainerStateUnknown},
+	}
+	if len(containers) != len(expected) {
+		t.Fatalf("unexpected containers: %+v", containers)
+	}
+	for i := range expected {
+		if containers[i] != expected[i] {
+			t.Fatalf("unexpected container[%d]: %+v, expected: %+v", i, containers[i], expected[i])
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package k8s_exec_pod
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"path"
+	"strings"
+)
+
+type ArchiveFormat string
+
+const (
+	ArchiveTar ArchiveFormat = "tar"
+	ArchiveZip ArchiveFormat = "zip"
+)
+
+const (
+	ErrDownloadLimitExceeded = "error: the download exceeded the limit of %d bytes"
+)
+
+// downloadScript archives the resolved file or directory by `tar cf - -C dir base`
+const downloadScript = resolvePathScript + `if [ "$p" = / ]; then exec tar cf - -C / .; fi
+exec tar cf - -C "${p%/*}/" -- "${p##*/}"`
+
+// Download archives the file or the directory of the container by running `tar cf - -C dir base`,
+// and writes the archive in the format into w. The symlinks of the path are resolved in the container, and the
+// target must be inside one of the roots. A regular file larger than maxBytes would be rejected before
+// anything was written, the download of a directory would be aborted once the tar stream exceeded maxBytes,
+// no limit if it was not positive.
+func Download(k8sClient kubernetes.Interface, cfg *rest.Config, option *ExecOptions, p string, roots []string, format ArchiveFormat, maxBytes int64, w io.Writer) error {
+	if maxBytes > 0 {
+		// the failure of the stat, e.g. the missing file, would be reported by the tar as well
+		if info, err := StatFile(k8sClient, cfg, option, p, roots); err == nil && info.Type == FileTypeRegular && info.Size > maxBytes {
+			return fmt.Errorf(ErrDownloadLimitExceeded, maxBytes)
+		}
+	}
+	opt := *option
+	opt.Command = []string{"sh", "-c", downloadScript, "sh", strings.Join(roots, "\n"), path.Clean(p)}
+	opt.Stdin = nil
+	var out io.Writer = w
+	var pw *io.PipeWriter
+	zipDone := make(chan error, 1)
+	if format == ArchiveZip {
+		var pr *io.PipeReader
+		pr, pw = io.Pipe()
+		out = pw
+		go func() {
+			err := tarToZip(pr, w)
+			_ = pr.CloseWithError(err)
+			zipDone <- err
+		}()
+	}
+	if maxBytes > 0 {
+		out = &limitWriter{w: out, n: maxBytes, max: maxBytes}
+	}
+	var stderr bytes.Buffer
+	err := ExecStream(k8sClient, cfg, &opt, out, &stderr)
+	if pw != nil {
+		_ = pw.CloseWithError(err)
+		if zipErr := <-zipDone; err == nil {
+			err = zipErr
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("error: download %s err:%v stderr:%s", p, err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// limitWriter fails the writes once more than max bytes were written, the empty writes are dropped
+// so that the response would not be committed before anything was written, e.g. the padding of the tar
+type limitWriter struct {
+	w   io.Writer
+	n   int64
+	max int64
+}
+
+func (l *limitWriter) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if int64(len(p)) > l.n {
+		return 0, fmt.Errorf(ErrDownloadLimitExceeded, l.max)
+	}
+	n, err := l.w.Write(p)
+	l.n -= int64(n)
+	return n, err
+}
+
+// tarToZip converts the tar stream into a zip archive on the fly, only the directories,
+// the regular files and the symlinks would be kept
+func tarToZip(r io.Reader, w io.Writer) error {
+	tr := tar.NewReader(r)
+	zw := zip.NewWriter(w)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		fh := &zip.FileHeader{
+			Name:     strings.TrimPrefix(header.Name, "./"),
+			Modified: header.ModTime,
+		}
+		fh.SetMode(header.FileInfo().Mode())
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if fh.Name == "" || fh.Name == "." {
+				continue
+			}
+			if !strings.HasSuffix(fh.Name, "/") {
+				fh.Name += "/"
+			}
+			if _, err = zw.CreateHeader(fh); err != nil {
+				return err
+			}
+		case tar.TypeReg, tar.TypeSymlink:
+			if header.Typeflag == tar.TypeReg {
+				fh.Method = zip.Deflate
+			}
+			fw, err := zw.CreateHeader(fh)
+			if err != nil {
+				return err
+			}
+			if header.Typeflag == tar.TypeSymlink {
+				// zip keeps the target of a symlink as its content
+				_, err = io.WriteString(fw, header.Linkname)
+			} else {
+				_, err = io.Copy(fw, tr)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return zw.Close()
+}
@@ -0,0 +1,96 @@
+package k8s_exec_pod
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"github.com/gin-gonic/gin"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTarToZip(t *testing.T) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	entries := []struct {
+		header  tar.Header
+		content string
+	}{
+		{tar.Header{Typeflag: tar.TypeDir, Name: "./", Mode: 0755}, ""},
+		{tar.Header{Typeflag: tar.TypeDir, Name: "./conf", Mode: 0755}, ""},
+		{tar.Header{Typeflag: tar.TypeReg, Name: "./conf/app.yaml", Mode: 0644, Size: 5}, "a: 1\n"},
+		{tar.Header{Typeflag: tar.TypeSymlink, Name: "./conf/latest", Linkname: "app.yaml", Mode: 0777}, ""},
+	}
+	for _, e := range entries {
+		header := e.header
+		if err := tw.WriteHeader(&header); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(e.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if err := tarToZip(&buf, &out); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(out.Bytes()), int64(out.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := make(map[string]string)
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[f.Name] = string(data)
+	}
+	if len(files) != 3 || files["conf/"] != "" || files["conf/app.yaml"] != "a: 1\n" || files["conf/latest"] != "app.yaml" {
+		t.Fatalf("unexpected zip files: %q", files)
+	}
+}
+
+func TestLimitWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := &limitWriter{w: &buf, n: 5, max: 5}
+	if _, err := w.Write([]byte("abc")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte("def")); err == nil {
+		t.Fatal("expected an error once the limit was exceeded")
+	}
+}
+
+func TestAbortResponse(t *testing.T) {
+	router := gin.New()
+	router.GET("/", func(c *gin.Context) {
+		c.Header("Content-Type", ContentTypeTar)
+		if _, err := c.Writer.Write(bytes.Repeat([]byte("x"), 1024)); err != nil {
+			t.Error(err)
+		}
+		c.Writer.Flush()
+		abortResponse(c)
+	})
+	srv := httptest.NewServer(router)
+	defer srv.Close()
+	res, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if _, err = ioutil.ReadAll(res.Body); err == nil {
+		t.Fatal("expected the truncated body was reported as an error")
+	}
+}
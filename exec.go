@@ -1,12 +1,23 @@
 package k8s_exec_pod
 
 import (
+	"bytes"
+	"context"
+	"fmt"
 	"github.com/Shanghai-Lunara/pkg/zaplogger"
+	"io"
 	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/remotecommand"
+	"strings"
+)
+
+const (
+	ErrCommandEmpty = "error: the command of the session:%v was empty"
 )
 
 // isValidShell checks if the shell is an allowed one
@@ -26,7 +37,11 @@ func Terminal(k8sClient kubernetes.Interface, cfg *rest.Config, session Session)
 	var err error
 	validShells := []string{"bash", "sh", "powershell", "cmd"}
 
-	if isValidShell(validShells, session.Option().Command[0]) {
+	if session.Option().Attach {
+		err = Attach(k8sClient, cfg, session)
+	} else if len(session.Option().Command) == 0 {
+		err = fmt.Errorf(ErrCommandEmpty, session.Id())
+	} else if isValidShell(validShells, session.Option().Command[0]) {
 		err = Exec(k8sClient, cfg, session)
 	} else {
 		// No shell given or it was not valid: try some shells until one succeeds or all fail
@@ -53,21 +68,63 @@ func Terminal(k8sClient kubernetes.Interface, cfg *rest.Config, session Session)
 func Exec(k8sClient kubernetes.Interface, cfg *rest.Config, session Session) error {
 	zaplogger.Sugar().Infof("startProcess Namespace:%s PodName:%s ContainerName:%s Command:%v",
 		session.Option().Namespace, session.Option().PodName, session.Option().ContainerName, session.Option().Command)
+	return stream(k8sClient, cfg, session, "exec", &corev1.PodExecOptions{
+		Container: session.Option().ContainerName,
+		Command:   session.Option().Command,
+		Stdin:     true,
+		Stdout:    true,
+		Stderr:    true,
+		TTY:       true,
+	}, true)
+}
+
+// Attach is called by Terminal
+// Attaches to the process running in the container specified in request and connects it up with the ptyHandler (a Session)
+// The container must have stdin enabled, the tty would be used only if the container has tty enabled
+func Attach(k8sClient kubernetes.Interface, cfg *rest.Config, session Session) error {
+	opt := session.Option()
+	zaplogger.Sugar().Infof("attachProcess Namespace:%s PodName:%s ContainerName:%s", opt.Namespace, opt.PodName, opt.ContainerName)
+	pod, err := k8sClient.CoreV1().Pods(opt.Namespace).Get(context.Background(), opt.PodName, metav1.GetOptions{})
+	if err != nil {
+		zaplogger.Sugar().Error(err)
+		return err
+	}
+	stdin, tty, found := false, false, false
+	for _, c := range pod.Spec.Containers {
+		if c.Name == opt.ContainerName {
+			stdin, tty, found = c.Stdin, c.TTY, true
+		}
+	}
+	for _, c := range pod.Spec.EphemeralContainers {
+		if c.Name == opt.ContainerName {
+			stdin, tty, found = c.Stdin, c.TTY, true
+		}
+	}
+	if !found {
+		return fmt.Errorf("error: the container:%s was not exist in pod:%s", opt.ContainerName, opt.PodName)
+	}
+	if !stdin {
+		return fmt.Errorf("error: the container:%s was not enabled stdin for attaching", opt.ContainerName)
+	}
+	return stream(k8sClient, cfg, session, "attach", &corev1.PodAttachOptions{
+		Container: opt.ContainerName,
+		Stdin:     true,
+		Stdout:    true,
+		Stderr:    !tty,
+		TTY:       tty,
+	}, tty)
+}
+
+// stream connects the subresource of the pod with the session
+func stream(k8sClient kubernetes.Interface, cfg *rest.Config, session Session, subResource string, params runtime.Object, tty bool) error {
 	req := k8sClient.CoreV1().RESTClient().Post().
 		Resource("pods").
 		Name(session.Option().PodName).
 		Namespace(session.Option().Namespace).
-		SubResource("exec").
-		VersionedParams(&corev1.PodExecOptions{
-			Container: session.Option().ContainerName,
-			Command:   session.Option().Command,
-			Stdin:     true,
-			Stdout:    true,
-			Stderr:    true,
-			TTY:       true,
-		}, scheme.ParameterCodec)
+		SubResource(subResource).
+		VersionedParams(params, scheme.ParameterCodec)
 
-	zaplogger.Sugar().Infow("Exec", "url", req.URL())
+	zaplogger.Sugar().Infow("Stream", "subResource", subResource, "url", req.URL())
 
 	exec, err := remotecommand.NewSPDYExecutor(cfg, "POST", req.URL())
 	if err != nil {
@@ -81,7 +138,7 @@ func Exec(k8sClient kubernetes.Interface, cfg *rest.Config, session Session) err
 		Stdout:            session,
 		Stderr:            session,
 		TerminalSizeQueue: session,
-		Tty:               true,
+		Tty:               tty,
 	})
 	if err != nil {
 		zaplogger.Sugar().Error(err)
@@ -90,3 +147,51 @@ func Exec(k8sClient kubernetes.Interface, cfg *rest.Config, session Session) err
 
 	return nil
 }
+
+// ExecStream executes option.Command in the container without a tty, option.Stdin would be streamed into the process
+// The stdout and the stderr of the process are written into stdout and stderr, either of them could be nil
+func ExecStream(k8sClient kubernetes.Interface, cfg *rest.Config, option *ExecOptions, stdout, stderr io.Writer) error {
+	zaplogger.Sugar().Infof("execStream Namespace:%s PodName:%s ContainerName:%s Command:%v",
+		option.Namespace, option.PodName, option.ContainerName, option.Command)
+	req := k8sClient.CoreV1().RESTClient().Post().
+		Resource("pods").
+		Name(option.PodName).
+		Namespace(option.Namespace).
+		SubResource("exec").
+		VersionedParams(&corev1.PodExecOptions{
+			Container: option.ContainerName,
+			Command:   option.Command,
+			Stdin:     option.Stdin != nil,
+			Stdout:    stdout != nil,
+			Stderr:    stderr != nil,
+			TTY:       false,
+		}, scheme.ParameterCodec)
+	exec, err := remotecommand.NewSPDYExecutor(cfg, "POST", req.URL())
+	if err != nil {
+		zaplogger.Sugar().Error(err)
+		return err
+	}
+	return exec.Stream(remotecommand.StreamOptions{
+		Stdin:  option.Stdin,
+		Stdout: stdout,
+		Stderr: stderr,
+		Tty:    false,
+	})
+}
+
+// ExecWithOptions executes option.Command in the container and returns the captured stdout and stderr
+func ExecWithOptions(k8sClient kubernetes.Interface, cfg *rest.Config, option *ExecOptions) (string, string, error) {
+	var stdout, stderr bytes.Buffer
+	var stdoutWriter, stderrWriter io.Writer
+	if option.CaptureStdout {
+		stdoutWriter = &stdout
+	}
+	if option.CaptureStderr {
+		stderrWriter = &stderr
+	}
+	err := ExecStream(k8sClient, cfg, option, stdoutWriter, stderrWriter)
+	if option.PreserveWhitespace {
+		return stdout.String(), stderr.String(), err
+	}
+	return strings.TrimSpace(stdout.String()), strings.TrimSpace(stderr.String()), err
+}
@@ -0,0 +1,203 @@
+package k8s_exec_pod
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type FileType string
+
+const (
+	FileTypeRegular   FileType = "file"
+	FileTypeDirectory FileType = "directory"
+	FileTypeSymlink   FileType = "symlink"
+	FileTypeDevice    FileType = "device"
+	FileTypePipe      FileType = "pipe"
+	FileTypeSocket    FileType = "socket"
+	FileTypeUnknown   FileType = "unknown"
+)
+
+// MaxFileReadBytes limits the byte range of ReadFile
+const MaxFileReadBytes = 1 << 20
+
+// statFormat is supported by both the coreutils and the busybox stat: raw mode in hex, size, mtime and name
+const statFormat = "%f|%s|%Y|%n"
+
+// resolvePathScript resolves the symlinks of the path $2 into $p inside the container, $1 are the allowed roots
+// separated by the line breaks, which are resolved as well. It fails unless $p was inside one of the roots,
+// so that a symlink under an allowed root could not lead the following script out of it.
+const resolvePathScript = `p=$(readlink -f -- "$2") || { echo "error: the path:$2 was not found" >&2; exit 1; }
+ok=
+set -f
+IFS='
+'
+for r in $1; do
+  r=$(readlink -f -- "$r") || continue
+  case "$p" in "$r"|"$r"/*) ok=1;; esac
+  if [ "$r" = / ]; then ok=1; fi
+done
+unset IFS
+set +f
+[ -n "$ok" ] || { echo "error: the path:$2 was not allowed" >&2; exit 1; }
+`
+
+// listDirScript prints the stat of every entry (including the hidden ones) of the resolved directory
+const listDirScript = resolvePathScript + `cd -- "$p" || exit 1
+for f in * .[!.]* ..?*; do
+  if [ -e "$f" ] || [ -L "$f" ]; then stat -c '` + statFormat + `' -- "$f"; fi
+done`
+
+// statFileScript prints the stat of the resolved file
+const statFileScript = resolvePathScript + `stat -c '` + statFormat + `' -- "$p"`
+
+// readFileScript prints $4 bytes of the resolved file from the 1-based offset $3
+const readFileScript = resolvePathScript + `tail -c +"$3" -- "$p" | head -c "$4"`
+
+// FileInfo describes a file inside a container
+type FileInfo struct {
+	Name    string    `json:"name"`
+	Type    FileType  `json:"type"`
+	Size    int64     `json:"size"`
+	Mode    string    `json:"mode"`
+	ModTime time.Time `json:"modTime"`
+}
+
+// ListDir lists the entries of the directory inside the container, sorted by the name.
+// The directory must be inside one of the roots once its symlinks were resolved in the container.
+func ListDir(k8sClient kubernetes.Interface, cfg *rest.Config, option *ExecOptions, dir string, roots []string) ([]FileInfo, error) {
+	stdout, err := execScript(k8sClient, cfg, option, listDirScript, strings.Join(roots, "\n"), dir)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]FileInfo, 0)
+	for _, line := range strings.Split(stdout, "\n") {
+		if line == "" {
+			continue
+		}
+		info, err := parseStatLine(line)
+		if err != nil {
+			// a name with a line break would not be parsed
+			continue
+		}
+		res = append(res, info)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Name < res[j].Name
+	})
+	return res, nil
+}
+
+// StatFile returns the FileInfo of the file inside the container, the Name would be the base name of the file.
+// The symlinks of the file are followed, and the target must be inside one of the roots.
+func StatFile(k8sClient kubernetes.Interface, cfg *rest.Config, option *ExecOptions, file string, roots []string) (*FileInfo, error) {
+	stdout, err := execScript(k8sClient, cfg, option, statFileScript, strings.Join(roots, "\n"), file)
+	if err != nil {
+		return nil, err
+	}
+	info, err := parseStatLine(strings.TrimRight(stdout, "\n"))
+	if err != nil {
+		return nil, err
+	}
+	info.Name = path.Base(file)
+	return &info, nil
+}
+
+// ReadFile writes length bytes of the file from the offset into w, length is limited by MaxFileReadBytes.
+// The symlinks of the file are followed, and the target must be inside one of the roots.
+func ReadFile(k8sClient kubernetes.Interface, cfg *rest.Config, option *ExecOptions, file string, roots []string, offset, length int64, w io.Writer) error {
+	if offset < 0 || length <= 0 || length > MaxFileReadBytes {
+		return fmt.Errorf("error: invalid range offset:%d length:%d, the max length is %d", offset, length, MaxFileReadBytes)
+	}
+	opt := *option
+	opt.Command = []string{"sh", "-c", readFileScript, "sh", strings.Join(roots, "\n"), file, strconv.FormatInt(offset+1, 10), strconv.FormatInt(length, 10)}
+	opt.Stdin = nil
+	var stderr bytes.Buffer
+	if err := ExecStream(k8sClient, cfg, &opt, w, &stderr); err != nil {
+		return fmt.Errorf("error: read %s err:%v stderr:%s", file, err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// execScript runs the shell script with the args as $1, $2... and returns the stdout
+func execScript(k8sClient kubernetes.Interface, cfg *rest.Config, option *ExecOptions, script string, args ...string) (string, error) {
+	opt := *option
+	opt.Command = append([]string{"sh", "-c", script, "sh"}, args...)
+	opt.Stdin = nil
+	opt.CaptureStdout = true
+	opt.CaptureStderr = true
+	opt.PreserveWhitespace = true
+	stdout, stderr, err := ExecWithOptions(k8sClient, cfg, &opt)
+	if err != nil {
+		return "", fmt.Errorf("error: %v stderr:%s", err, strings.TrimSpace(stderr))
+	}
+	return stdout, nil
+}
+
+// parseStatLine parses a line printed with the statFormat
+func parseStatLine(line string) (FileInfo, error) {
+	fields := strings.SplitN(line, "|", 4)
+	if len(fields) != 4 {
+		return FileInfo{}, fmt.Errorf("error: invalid stat line:%q", line)
+	}
+	raw, err := strconv.ParseUint(fields[0], 16, 32)
+	if err != nil {
+		return FileInfo{}, fmt.Errorf("error: invalid mode:%q err:%v", fields[0], err)
+	}
+	size, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return FileInfo{}, fmt.Errorf("error: invalid size:%q err:%v", fields[1], err)
+	}
+	mtime, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil {
+		return FileInfo{}, fmt.Errorf("error: invalid mtime:%q err:%v", fields[2], err)
+	}
+	t, mode := parseRawMode(uint32(raw))
+	return FileInfo{
+		Name:    fields[3],
+		Type:    t,
+		Size:    size,
+		Mode:    mode.String(),
+		ModTime: time.Unix(mtime, 0).UTC(),
+	}, nil
+}
+
+// parseRawMode converts the unix st_mode into the FileType and the os.FileMode
+func parseRawMode(raw uint32) (FileType, os.FileMode) {
+	mode := os.FileMode(raw & 0777)
+	if raw&04000 != 0 {
+		mode |= os.ModeSetuid
+	}
+	if raw&02000 != 0 {
+		mode |= os.ModeSetgid
+	}
+	if raw&01000 != 0 {
+		mode |= os.ModeSticky
+	}
+	switch raw & 0170000 {
+	case 0100000:
+		return FileTypeRegular, mode
+	case 0040000:
+		return FileTypeDirectory, mode | os.ModeDir
+	case 0120000:
+		return FileTypeSymlink, mode | os.ModeSymlink
+	case 0020000:
+		return FileTypeDevice, mode | os.ModeDevice | os.ModeCharDevice
+	case 0060000:
+		return FileTypeDevice, mode | os.ModeDevice
+	case 0010000:
+		return FileTypePipe, mode | os.ModeNamedPipe
+	case 0140000:
+		return FileTypeSocket, mode | os.ModeSocket
+	default:
+		return FileTypeUnknown, mode | os.ModeIrregular
+	}
+}
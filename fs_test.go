@@ -0,0 +1,99 @@
+package k8s_exec_pod
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseStatLine(t *testing.T) {
+	for line, expected := range map[string]FileInfo{
+		"81a4|1024|1700000000|app.log":     {Name: "app.log", Type: FileTypeRegular, Size: 1024, Mode: "-rw-r--r--"},
+		"41ed|4096|1700000000|conf.d":      {Name: "conf.d", Type: FileTypeDirectory, Size: 4096, Mode: "drwxr-xr-x"},
+		"a1ff|7|1700000000|latest":         {Name: "latest", Type: FileTypeSymlink, Size: 7, Mode: "Lrwxrwxrwx"},
+		"43ff|4096|1700000000|tmp":         {Name: "tmp", Type: FileTypeDirectory, Size: 4096, Mode: "dtrwxrwxrwx"},
+		"81a4|3|1700000000|name|with|bars": {Name: "name|with|bars", Type: FileTypeRegular, Size: 3, Mode: "-rw-r--r--"},
+	} {
+		info, err := parseStatLine(line)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected.ModTime = time.Unix(1700000000, 0).UTC()
+		if info != expected {
+			t.Fatalf("unexpected FileInfo of %q: %+v, expected: %+v", line, info, expected)
+		}
+	}
+	if _, err := parseStatLine("stat: can't stat 'x': No such file or directory"); err == nil {
+		t.Fatal("expected an error for the invalid line")
+	}
+}
+
+// TestResolvePathScript runs the script by the local sh, the symlinks leaving the roots must be rejected
+func TestResolvePathScript(t *testing.T) {
+	for _, bin := range []string{"sh", "readlink"} {
+		if _, err := exec.LookPath(bin); err != nil {
+			t.Skipf("the %s was not found", bin)
+		}
+	}
+	tmp, err := ioutil.TempDir("", "k8s-exec-pod-resolve-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	if tmp, err = filepath.EvalSymlinks(tmp); err != nil {
+		t.Fatal(err)
+	}
+	for _, dir := range []string{"data/logs", "etc"} {
+		if err = os.MkdirAll(filepath.Join(tmp, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for link, target := range map[string]string{
+		"data/escape": filepath.Join(tmp, "etc"),
+		"data/latest": "logs",
+		"link-data":   "data",
+	} {
+		if err = os.Symlink(target, filepath.Join(tmp, link)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	resolve := func(roots []string, p string) (string, error) {
+		out, err := exec.Command("sh", "-c", resolvePathScript+`printf %s "$p"`, "sh", strings.Join(roots, "\n"), p).CombinedOutput()
+		if err != nil {
+			return "", fmt.Errorf("%v: %s", err, out)
+		}
+		return string(out), nil
+	}
+	data := filepath.Join(tmp, "data")
+	for _, v := range []struct {
+		roots    []string
+		p        string
+		expected string
+	}{
+		{roots: []string{data}, p: data + "/latest", expected: data + "/logs"},
+		{roots: []string{tmp + "/link-data"}, p: tmp + "/link-data/logs", expected: data + "/logs"},
+		{roots: []string{"/etc", data}, p: data + "/logs/app.log", expected: data + "/logs/app.log"},
+		{roots: []string{"/"}, p: data + "/escape", expected: tmp + "/etc"},
+		{roots: []string{data}, p: data + "/escape"},
+		{roots: []string{data}, p: data + "/escape/passwd"},
+		{roots: []string{data}, p: tmp + "/link-data/escape"},
+		{roots: []string{data + "/logs"}, p: data + "/logs/../escape"},
+		{roots: nil, p: data},
+	} {
+		res, err := resolve(v.roots, v.p)
+		if v.expected == "" {
+			if err == nil || !strings.Contains(err.Error(), fmt.Sprintf(ErrPathNotAllowed, v.p)) {
+				t.Fatalf("expected %s was not allowed by %v, got %q err:%v", v.p, v.roots, res, err)
+			}
+			continue
+		}
+		if err != nil || res != v.expected {
+			t.Fatalf("expected %s was resolved into %s by %v, got %q err:%v", v.p, v.expected, v.roots, res, err)
+		}
+	}
+}
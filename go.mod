@@ -8,8 +8,10 @@ require (
 	github.com/gin-contrib/cors v1.3.1
 	github.com/gin-gonic/gin v1.7.0
 	github.com/gorilla/websocket v1.4.2
+	github.com/klauspost/compress v1.13.6
 	k8s.io/api v0.24.3
 	k8s.io/apimachinery v0.24.3
 	k8s.io/client-go v0.24.3
 	k8s.io/klog/v2 v2.60.1
+	sigs.k8s.io/yaml v1.2.0
 )
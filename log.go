@@ -2,32 +2,72 @@ package k8s_exec_pod
 
 import (
 	"context"
+	"fmt"
 	"github.com/Shanghai-Lunara/pkg/zaplogger"
 	"io"
 	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/kubernetes/scheme"
+	"strconv"
+	"time"
 )
 
-func openStream(k8sClient kubernetes.Interface, option *ExecOptions) (io.ReadCloser, error) {
-	rc, err := k8sClient.CoreV1().RESTClient().Get().
-		Resource("pods").
-		Namespace(option.Namespace).
-		Name(option.PodName).
-		SubResource("log").
-		VersionedParams(&corev1.PodLogOptions{
-			Container:    option.ContainerName,
-			Follow:       option.Follow,
-			Previous:     option.UsePreviousLogs,
-			Timestamps:   false,
-			SinceSeconds: option.SinceSeconds,
-			//SinceTime:    option.SinceTime,
-		}, scheme.ParameterCodec).Stream(context.Background())
+const (
+	ErrInvalidSinceSeconds = "error: invalid sinceSeconds:%v, it must be a positive integer"
+	ErrInvalidSinceTime    = "error: invalid sinceTime:%v, it must be a RFC3339 time"
+	ErrSinceConflict       = "error: only one of sinceSeconds and sinceTime could be specified"
+)
+
+// parseSince parses the sinceSeconds and the RFC3339 sinceTime, an empty value or "0" means absent.
+// At most one of them could be specified, and the sinceTime must not be in the future.
+func parseSince(sinceSeconds, sinceTime string) (*int64, *metav1.Time, error) {
+	var (
+		seconds *int64
+		t       *metav1.Time
+	)
+	if sinceSeconds != "" && sinceSeconds != "0" {
+		v, err := strconv.ParseInt(sinceSeconds, 10, 64)
+		if err != nil || v < 0 {
+			return nil, nil, fmt.Errorf(ErrInvalidSinceSeconds, sinceSeconds)
+		}
+		seconds = &v
+	}
+	if sinceTime != "" && sinceTime != "0" {
+		v, err := time.Parse(time.RFC3339, sinceTime)
+		if err != nil {
+			return nil, nil, fmt.Errorf(ErrInvalidSinceTime, sinceTime)
+		}
+		if v.After(time.Now()) {
+			return nil, nil, fmt.Errorf("error: the sinceTime:%v was in the future", sinceTime)
+		}
+		t = &metav1.Time{Time: v}
+	}
+	if seconds != nil && t != nil {
+		return nil, nil, fmt.Errorf(ErrSinceConflict)
+	}
+	return seconds, t, nil
+}
+
+func openStream(ctx context.Context, k8sClient kubernetes.Interface, option *ExecOptions) (io.ReadCloser, error) {
+	rc, err := k8sClient.CoreV1().Pods(option.Namespace).GetLogs(option.PodName, &corev1.PodLogOptions{
+		Container:                    option.ContainerName,
+		Follow:                       option.Follow,
+		Previous:                     option.UsePreviousLogs,
+		Timestamps:                   option.Timestamps,
+		SinceSeconds:                 option.SinceSeconds,
+		SinceTime:                    option.SinceTime,
+		TailLines:                    option.TailLines,
+		LimitBytes:                   option.LimitBytes,
+		InsecureSkipTLSVerifyBackend: option.InsecureSkipTLSVerifyBackend,
+	}).Stream(ctx)
 	return rc, err
 }
 
 func LogTransmit(k8sClient kubernetes.Interface, session Session) error {
-	readCloser, err := openStream(k8sClient, session.Option())
+	if opt := session.Option(); opt.Follow && opt.FollowRestarts {
+		return logTransmitAcrossRestarts(k8sClient, session)
+	}
+	readCloser, err := openStream(session.Ctx(), k8sClient, session.Option())
 	if err != nil {
 		zaplogger.Sugar().Error(err)
 		session.Close(err.Error())
@@ -40,10 +80,23 @@ func LogTransmit(k8sClient kubernetes.Interface, session Session) error {
 			zaplogger.Sugar().Error(err)
 		}
 	}()
-	zaplogger.Sugar().Info("LogTransmit io.Copy start session:", session.Id())
-	if _, err = io.Copy(session, readCloser); err != nil {
-		session.Close(err.Error())
-		return err
+	if session.Option().LogFormat != "" {
+		zaplogger.Sugar().Info("LogTransmit transmitLines start session:", session.Id())
+		if _, err = transmitLines(session, session.Option(), readCloser, session.Option().Timestamps, time.Time{}); err != nil {
+			session.Close(err.Error())
+			return err
+		}
+	} else {
+		zaplogger.Sugar().Info("LogTransmit io.Copy start session:", session.Id())
+		w := &logFilterWriter{session: session}
+		if _, err = io.Copy(w, readCloser); err != nil {
+			session.Close(err.Error())
+			return err
+		}
+		if err = w.Flush(); err != nil {
+			session.Close(err.Error())
+			return err
+		}
 	}
 	zaplogger.Sugar().Infof("LogTransmit trigger session.Close session:%s reason:%s", session.Id(), ReasonStreamStopped)
 	session.Close(ReasonStreamStopped)
@@ -51,5 +104,5 @@ func LogTransmit(k8sClient kubernetes.Interface, session Session) error {
 }
 
 func LogDownload(k8sClient kubernetes.Interface, option *ExecOptions) (io.ReadCloser, error) {
-	return openStream(k8sClient, option)
+	return openStream(context.Background(), k8sClient, option)
 }
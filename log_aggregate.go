@@ -0,0 +1,211 @@
+package k8s_exec_pod
+
+import (
+	"context"
+	"github.com/Shanghai-Lunara/pkg/zaplogger"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	"strings"
+	"sync"
+	"time"
+)
+
+// podWatchRetryInterval is the interval of re-watching the pods after the watch was closed
+const podWatchRetryInterval = time.Second * 2
+
+// AggregateLogTransmit is called from Session as a goroutine instead of LogTransmit if ExecOptions.Selector was set.
+// It streams the logs of every running container of the pods matching the selector onto the session line by line,
+// the pods are watched so that the streams are added and dropped as the pods come and go during a rollout.
+// ExecOptions.ContainerName filters the containers if it was not empty.
+func AggregateLogTransmit(k8sClient kubernetes.Interface, session Session) error {
+	opt := session.Option()
+	selector, err := labels.Parse(opt.Selector)
+	if err != nil {
+		zaplogger.Sugar().Error(err)
+		session.Close(err.Error())
+		return err
+	}
+	a := &logAggregator{
+		k8sClient: k8sClient,
+		session:   session,
+		streams:   make(map[string]*aggregatedStream),
+	}
+	pods := k8sClient.CoreV1().Pods(opt.Namespace)
+	ctx := session.Ctx()
+	for {
+		list, err := pods.List(ctx, metav1.ListOptions{LabelSelector: selector.String()})
+		if err != nil {
+			zaplogger.Sugar().Error(err)
+			session.Close(err.Error())
+			return err
+		}
+		a.sync(list.Items)
+		w, err := pods.Watch(ctx, metav1.ListOptions{LabelSelector: selector.String(), ResourceVersion: list.ResourceVersion})
+		if err != nil {
+			zaplogger.Sugar().Error(err)
+			session.Close(err.Error())
+			return err
+		}
+		a.watch(w)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(podWatchRetryInterval):
+		}
+	}
+}
+
+type logAggregator struct {
+	k8sClient kubernetes.Interface
+	session   Session
+
+	mu sync.Mutex
+	// streams are keyed by `pod/container`
+	streams map[string]*aggregatedStream
+}
+
+// aggregatedStream is owned by the goroutine of its stream, a stream of the pod which was recreated with the same
+// name would be a new one under the same key
+type aggregatedStream struct {
+	cancel context.CancelFunc
+}
+
+// watch handles the events until the watch was closed or the session was done
+func (a *logAggregator) watch(w watch.Interface) {
+	defer w.Stop()
+	for {
+		select {
+		case <-a.session.Ctx().Done():
+			return
+		case event, ok := <-w.ResultChan():
+			if !ok {
+				return
+			}
+			pod, ok := event.Object.(*corev1.Pod)
+			if !ok {
+				continue
+			}
+			switch event.Type {
+			case watch.Added, watch.Modified:
+				a.update(pod)
+			case watch.Deleted:
+				a.remove(pod.Name)
+			}
+		}
+	}
+}
+
+// sync starts the streams of the pods and stops the streams of the pods which were gone
+func (a *logAggregator) sync(pods []corev1.Pod) {
+	exist := make(map[string]bool, len(pods))
+	for i := range pods {
+		exist[pods[i].Name] = true
+		a.update(&pods[i])
+	}
+	a.mu.Lock()
+	gone := make([]string, 0)
+	for key := range a.streams {
+		if pod, _ := splitStreamKey(key); !exist[pod] {
+			gone = append(gone, pod)
+		}
+	}
+	a.mu.Unlock()
+	for _, pod := range gone {
+		a.remove(pod)
+	}
+}
+
+// update starts the streams of the running containers of the pod which were not streaming yet
+func (a *logAggregator) update(pod *corev1.Pod) {
+	if pod.DeletionTimestamp != nil {
+		a.remove(pod.Name)
+		return
+	}
+	filter := a.session.Option().ContainerName
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.State.Running == nil || (filter != "" && status.Name != filter) {
+			continue
+		}
+		key := pod.Name + "/" + status.Name
+		a.mu.Lock()
+		if _, ok := a.streams[key]; ok {
+			a.mu.Unlock()
+			continue
+		}
+		ctx, cancel := context.WithCancel(a.session.Ctx())
+		st := &aggregatedStream{cancel: cancel}
+		a.streams[key] = st
+		a.mu.Unlock()
+		go a.stream(ctx, st, key, pod.Name, status.Name)
+	}
+}
+
+// remove stops all the streams of the pod
+func (a *logAggregator) remove(pod string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for key, st := range a.streams {
+		if p, _ := splitStreamKey(key); p == pod {
+			st.cancel()
+			delete(a.streams, key)
+		}
+	}
+}
+
+// release stops the stream, the key would be deleted only if it was still owned by the stream
+func (a *logAggregator) release(key string, st *aggregatedStream) {
+	st.cancel()
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.streams[key] == st {
+		delete(a.streams, key)
+	}
+}
+
+// stream copies the log of the container onto the session line by line until the stream ended or ctx was done
+func (a *logAggregator) stream(ctx context.Context, st *aggregatedStream, key, pod, container string) {
+	defer func() {
+		a.release(key, st)
+		a.control(ControlLogStreamStopped, key)
+	}()
+	opt := *a.session.Option()
+	opt.PodName = pod
+	opt.ContainerName = container
+	opt.Follow = true
+	opt.UsePreviousLogs = false
+	rc, err := openStream(ctx, a.k8sClient, &opt)
+	if err != nil {
+		zaplogger.Sugar().Errorw("AggregateLogTransmit open stream failed", "stream", key, "err", err)
+		return
+	}
+	defer func() {
+		if err := rc.Close(); err != nil {
+			zaplogger.Sugar().Error(err)
+		}
+	}()
+	a.control(ControlLogStreamStarted, key)
+	if _, err = transmitLines(a.session, &opt, rc, opt.Timestamps, time.Time{}); err != nil && ctx.Err() == nil {
+		zaplogger.Sugar().Errorw("AggregateLogTransmit read stream failed", "stream", key, "err", err)
+	}
+}
+
+func (a *logAggregator) control(t ControlMessageType, key string) {
+	if a.session.Ctx().Err() != nil {
+		return
+	}
+	if err := a.session.Control(&ControlMsg{MsgType: t, Message: key}); err != nil {
+		zaplogger.Sugar().Error(err)
+	}
+}
+
+// splitStreamKey splits the `pod/container` key
+func splitStreamKey(key string) (pod, container string) {
+	res := strings.SplitN(key, "/", 2)
+	if len(res) == 1 {
+		return res[0], ""
+	}
+	return res[0], res[1]
+}
@@ -0,0 +1,117 @@
+package k8s_exec_pod
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/websocket"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sort"
+	"testing"
+	"time"
+)
+
+func newRunningPod(name string, labels map[string]string, containers ...string) *corev1.Pod {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", Labels: labels},
+		Status:     corev1.PodStatus{Phase: corev1.PodRunning},
+	}
+	for _, c := range containers {
+		pod.Spec.Containers = append(pod.Spec.Containers, corev1.Container{Name: c})
+		pod.Status.ContainerStatuses = append(pod.Status.ContainerStatuses, corev1.ContainerStatus{
+			Name:  c,
+			State: corev1.ContainerState{Running: &corev1.ContainerStateRunning{}},
+		})
+	}
+	return pod
+}
+
+func TestAggregateLogTransmit(t *testing.T) {
+	s, _ := newFakeServer(t, time.Millisecond*100,
+		newRunningPod("web-0", map[string]string{"app": "web"}, "app", "sidecar"),
+		newRunningPod("web-1", map[string]string{"app": "web"}, "app"),
+		newRunningPod("db-0", map[string]string{"app": "db"}, "db"),
+	)
+	defer s.ShutDown()
+
+	var res HttpResponse
+	getJSON(t, fmt.Sprintf("http://%s/cluster/%s/namespace/default/logs?selector=app%%3Dweb&format=json", s.Addr(), fakeClusterName), &res)
+	if res.Code != CodeSuccess || res.Token == "" {
+		t.Fatalf("unexpected token response: %+v", res)
+	}
+	u := fmt.Sprintf("ws://%s/cluster/%s/log/sinceSeconds/0/sinceTime/0/token/%s", s.Addr(), fakeClusterName, res.Token)
+	ws, _, err := websocket.DefaultDialer.Dial(u, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	_ = ws.SetReadDeadline(time.Now().Add(time.Second * 5))
+	lines := make([]string, 0)
+	stopped := 0
+	for stopped < 3 {
+		messageType, data, err := ws.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if messageType == websocket.TextMessage {
+			var msg ControlMsg
+			if err = json.Unmarshal(data, &msg); err != nil {
+				t.Fatal(err)
+			}
+			if msg.MsgType == ControlLogStreamStopped {
+				stopped++
+			}
+			continue
+		}
+		var line LogLine
+		if err = json.Unmarshal(data, &line); err != nil {
+			t.Fatalf("unexpected line %q err:%v", data, err)
+		}
+		lines = append(lines, fmt.Sprintf("%s/%s:%s", line.Pod, line.Container, line.Raw))
+	}
+	sort.Strings(lines)
+	expected := []string{"web-0/app:fake logs", "web-0/sidecar:fake logs", "web-1/app:fake logs"}
+	if fmt.Sprint(lines) != fmt.Sprint(expected) {
+		t.Fatalf("expected lines %v, got %v", expected, lines)
+	}
+}
+
+func TestLogAggregateTokenInvalidSelector(t *testing.T) {
+	s, _ := newFakeServer(t, time.Millisecond*100)
+	defer s.ShutDown()
+	for _, query := range []string{"", "selector=a%3D%3D%3Db", "format=xml&selector=app%3Dweb", "kind=cronjob&name=web"} {
+		var res HttpResponse
+		getJSON(t, fmt.Sprintf("http://%s/namespace/default/logs?%s", s.Addr(), query), &res)
+		if res.Code != CodeError {
+			t.Fatalf("expected an error for the query %q, got %+v", query, res)
+		}
+	}
+}
+
+func TestLogAggregatorRelease(t *testing.T) {
+	a := &logAggregator{streams: make(map[string]*aggregatedStream)}
+	newStream := func() (*aggregatedStream, context.Context) {
+		ctx, cancel := context.WithCancel(context.Background())
+		return &aggregatedStream{cancel: cancel}, ctx
+	}
+	old, oldCtx := newStream()
+	a.streams["web-0/app"] = old
+
+	// the pod was deleted and recreated with the same name before the old stream returned
+	a.remove("web-0")
+	recreated, recreatedCtx := newStream()
+	a.streams["web-0/app"] = recreated
+	a.release("web-0/app", old)
+	if oldCtx.Err() == nil {
+		t.Fatal("expected the old stream was canceled")
+	}
+	if a.streams["web-0/app"] != recreated || recreatedCtx.Err() != nil {
+		t.Fatal("expected the stream of the recreated pod was kept by the release of the old one")
+	}
+
+	a.release("web-0/app", recreated)
+	if _, ok := a.streams["web-0/app"]; ok || recreatedCtx.Err() == nil {
+		t.Fatal("expected the stream was released by its owner")
+	}
+}
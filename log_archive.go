@@ -0,0 +1,285 @@
+package k8s_exec_pod
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"github.com/Shanghai-Lunara/pkg/zaplogger"
+	"github.com/klauspost/compress/zstd"
+	"io"
+	"io/ioutil"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Compression of the downloads
+type Compression string
+
+const (
+	CompressionNone Compression = "none"
+	CompressionGzip Compression = "gzip"
+	CompressionZstd Compression = "zstd"
+)
+
+const (
+	ErrCompressionNotSupported = "error: the compression:%v was not supported"
+)
+
+const (
+	ContentTypeGzip = "application/gzip"
+	ContentTypeZstd = "application/zstd"
+)
+
+// parseCompression parses the `compress` query, an empty one means CompressionNone
+func parseCompression(compress string) (Compression, error) {
+	switch c := Compression(strings.ToLower(compress)); c {
+	case "", CompressionNone:
+		return CompressionNone, nil
+	case CompressionGzip, CompressionZstd:
+		return c, nil
+	default:
+		return "", fmt.Errorf(ErrCompressionNotSupported, compress)
+	}
+}
+
+// acceptEncoding returns the compression of the Accept-Encoding header by the q-values of its codings,
+// zstd was preferred over gzip for the same q-value. CompressionNone would be returned if neither was accepted.
+func acceptEncoding(header string) Compression {
+	res, best := CompressionNone, 0.0
+	for _, v := range strings.Split(header, ",") {
+		parts := strings.Split(v, ";")
+		c := Compression(strings.ToLower(strings.TrimSpace(parts[0])))
+		if c != CompressionGzip && c != CompressionZstd {
+			continue
+		}
+		q := 1.0
+		for _, param := range parts[1:] {
+			param = strings.TrimSpace(param)
+			if !strings.HasPrefix(param, "q=") {
+				continue
+			}
+			var err error
+			if q, err = strconv.ParseFloat(strings.TrimPrefix(param, "q="), 64); err != nil {
+				q = 0
+			}
+		}
+		// q=0 refuses the coding
+		if q > best || (q == best && q > 0 && c == CompressionZstd) {
+			res, best = c, q
+		}
+	}
+	return res
+}
+
+// Extension returns the file extension of the compression, e.g. `.gz`
+func (c Compression) Extension() string {
+	switch c {
+	case CompressionGzip:
+		return ".gz"
+	case CompressionZstd:
+		return ".zst"
+	}
+	return ""
+}
+
+// ContentType returns the Content-Type of a file compressed by the compression, or the uncompressed one
+func (c Compression) ContentType(uncompressed string) string {
+	switch c {
+	case CompressionGzip:
+		return ContentTypeGzip
+	case CompressionZstd:
+		return ContentTypeZstd
+	}
+	return uncompressed
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+// compressWriter wraps w by the compression, the returned writer must be closed for flushing the compressed data
+func compressWriter(w io.Writer, c Compression) (io.WriteCloser, error) {
+	switch c {
+	case CompressionNone:
+		return nopWriteCloser{w}, nil
+	case CompressionGzip:
+		return gzip.NewWriter(w), nil
+	case CompressionZstd:
+		return zstd.NewWriter(w)
+	default:
+		return nil, fmt.Errorf(ErrCompressionNotSupported, c)
+	}
+}
+
+// logArchiveContainers returns the containers of the pod, init containers first.
+// Only the named container would be returned if it was not empty.
+func logArchiveContainers(pod *corev1.Pod, container string) ([]string, error) {
+	res := make([]string, 0, len(pod.Spec.InitContainers)+len(pod.Spec.Containers))
+	for _, c := range pod.Spec.InitContainers {
+		res = append(res, c.Name)
+	}
+	for _, c := range pod.Spec.Containers {
+		res = append(res, c.Name)
+	}
+	if container == "" {
+		return res, nil
+	}
+	for _, name := range res {
+		if name == container {
+			return []string{name}, nil
+		}
+	}
+	return nil, fmt.Errorf("error: the container:%s was not found in the pod:%s", container, pod.Name)
+}
+
+// WriteLogArchive writes the logs of the containers of the pod as `<dir><container>.log` files into the tar,
+// only the logs of ExecOptions.ContainerName would be written if it was not empty.
+// The previous logs would be written as `<container>.previous.log` files as well if previous was true and the
+// container was restarted. The option decides the other PodLogOptions, the logs would never be followed.
+// The streams are opened with the ctx, a log larger than maxBytes would fail the archive before it was written,
+// no limit if it was not positive. The logs are streamed into the tar without being spooled.
+func WriteLogArchive(ctx context.Context, k8sClient kubernetes.Interface, option *ExecOptions, pod *corev1.Pod, previous bool, maxBytes int64, dir string, tw *tar.Writer) error {
+	containers, err := logArchiveContainers(pod, option.ContainerName)
+	if err != nil {
+		return err
+	}
+	restarted := make(map[string]bool)
+	for _, statuses := range [][]corev1.ContainerStatus{pod.Status.InitContainerStatuses, pod.Status.ContainerStatuses} {
+		for _, status := range statuses {
+			restarted[status.Name] = status.RestartCount > 0
+		}
+	}
+	for _, container := range containers {
+		if err = writeLogFile(ctx, k8sClient, option, pod.Name, container, false, maxBytes, dir+container+".log", tw); err != nil {
+			return err
+		}
+		if !previous || !restarted[container] {
+			continue
+		}
+		if err = writeLogFile(ctx, k8sClient, option, pod.Name, container, true, maxBytes, dir+container+".previous.log", tw); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeLogFile writes the log of the container into the tar without spooling it, the error of opening the stream
+// would be written into the file instead, e.g. the container was not started yet.
+// The log is read twice, once for the size of the tar header and once for the content, which is truncated or padded
+// by the line breaks to that size since the log could grow or be rotated in between. The SinceSeconds is fixed into
+// the SinceTime so that both reads cover the same range. A log larger than maxBytes fails before it was written.
+func writeLogFile(ctx context.Context, k8sClient kubernetes.Interface, option *ExecOptions, pod, container string, previous bool, maxBytes int64, name string, tw *tar.Writer) error {
+	opt := *option
+	opt.PodName = pod
+	opt.ContainerName = container
+	opt.UsePreviousLogs = previous
+	opt.Follow = false
+	if opt.SinceSeconds != nil {
+		opt.SinceTime = &metav1.Time{Time: time.Now().Add(-time.Second * time.Duration(*opt.SinceSeconds))}
+		opt.SinceSeconds = nil
+	}
+	limit := int64(0)
+	if maxBytes > 0 {
+		// an extra byte tells the log larger than maxBytes
+		limit = maxBytes + 1
+	}
+	size, err := readLogFile(ctx, k8sClient, &opt, limit, ioutil.Discard)
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if _, ok := err.(logOpenError); !ok {
+			return err
+		}
+		zaplogger.Sugar().Errorw("WriteLogArchive open stream failed", "pod", opt.PodName, "container", container, "previous", previous, "err", err)
+		return writeTarEntry(tw, name+".error", strings.NewReader(err.Error()), int64(len(err.Error())))
+	}
+	if maxBytes > 0 && size > maxBytes {
+		return fmt.Errorf(ErrDownloadLimitExceeded, maxBytes)
+	}
+	if err = tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     0644,
+		Size:     size,
+		ModTime:  time.Now(),
+	}); err != nil || size == 0 {
+		return err
+	}
+	// the LimitBytes of the PodLogOptions must be positive
+	opt.LimitBytes = &size
+	n, err := readLogFile(ctx, k8sClient, &opt, size, tw)
+	if _, ok := err.(logOpenError); ok && ctx.Err() == nil {
+		// the log was gone in between, e.g. the container was removed, the entry is padded as well
+		zaplogger.Sugar().Errorw("WriteLogArchive reopen stream failed", "pod", opt.PodName, "container", container, "previous", previous, "err", err)
+		err = nil
+	}
+	if err != nil {
+		return err
+	}
+	_, err = io.CopyN(tw, newlineReader{}, size-n)
+	return err
+}
+
+// logOpenError is the error of opening the log stream
+type logOpenError struct {
+	error
+}
+
+// readLogFile copies up to limit bytes of the log into w, no limit if it was not positive
+func readLogFile(ctx context.Context, k8sClient kubernetes.Interface, option *ExecOptions, limit int64, w io.Writer) (int64, error) {
+	rc, err := openStream(ctx, k8sClient, option)
+	if err != nil {
+		return 0, logOpenError{err}
+	}
+	defer func() {
+		if err := rc.Close(); err != nil {
+			zaplogger.Sugar().Error(err)
+		}
+	}()
+	var r io.Reader = rc
+	if limit > 0 {
+		r = io.LimitReader(rc, limit)
+	}
+	return io.Copy(w, r)
+}
+
+// newlineReader reads the line breaks endlessly
+type newlineReader struct{}
+
+func (newlineReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = '\n'
+	}
+	return len(p), nil
+}
+
+// writeTarArchive writes the tar into w by the compression, the write would fail once the tar stream
+// exceeded maxBytes, no limit if it was not positive
+func writeTarArchive(w io.Writer, compression Compression, maxBytes int64, write func(tw *tar.Writer) error) error {
+	cw, err := compressWriter(w, compression)
+	if err != nil {
+		return err
+	}
+	var out io.Writer = cw
+	if maxBytes > 0 {
+		out = &limitWriter{w: cw, n: maxBytes, max: maxBytes}
+	}
+	tw := tar.NewWriter(out)
+	if err = write(tw); err != nil {
+		return err
+	}
+	if err = tw.Close(); err != nil {
+		return err
+	}
+	return cw.Close()
+}
@@ -0,0 +1,219 @@
+package k8s_exec_pod
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"github.com/klauspost/compress/zstd"
+	"io/ioutil"
+	"k8s.io/client-go/kubernetes/fake"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestParseCompression(t *testing.T) {
+	for _, c := range []struct {
+		compress string
+		expected Compression
+		err      bool
+	}{
+		{"", CompressionNone, false},
+		{"none", CompressionNone, false},
+		{"GZIP", CompressionGzip, false},
+		{"zstd", CompressionZstd, false},
+		{"br", "", true},
+	} {
+		res, err := parseCompression(c.compress)
+		if (err != nil) != c.err || res != c.expected {
+			t.Fatalf("parseCompression(%q) expected %q err:%v, got %q err:%v", c.compress, c.expected, c.err, res, err)
+		}
+	}
+	for _, c := range []struct {
+		acceptEncoding string
+		expected       Compression
+	}{
+		{"", CompressionNone},
+		{"gzip", CompressionGzip},
+		{"deflate, gzip;q=0.8", CompressionGzip},
+		{"gzip;q=0", CompressionNone},
+		{"br", CompressionNone},
+		{"zstd", CompressionZstd},
+		{"gzip, zstd", CompressionZstd},
+		{"gzip, zstd;q=0.5", CompressionGzip},
+		{"gzip;q=0.5, ZSTD;q=0.9", CompressionZstd},
+		{"zstd;q=0, gzip;q=0.1", CompressionGzip},
+	} {
+		if res := acceptEncoding(c.acceptEncoding); res != c.expected {
+			t.Fatalf("acceptEncoding(%q) expected %q, got %q", c.acceptEncoding, c.expected, res)
+		}
+	}
+}
+
+func TestLogArchive(t *testing.T) {
+	pod := newRunningPod("web-0", nil, "app", "sidecar")
+	pod.Status.ContainerStatuses[0].RestartCount = 1
+	s, _ := newFakeServer(t, time.Millisecond*100, pod)
+	defer s.ShutDown()
+
+	res, err := http.Get(fmt.Sprintf("http://%s/cluster/%s/namespace/default/pod/web-0/logs/archive", s.Addr(), fakeClusterName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if res.Header.Get("Content-Type") != ContentTypeGzip {
+		t.Fatalf("unexpected Content-Type:%s", res.Header.Get("Content-Type"))
+	}
+	zr, err := gzip.NewReader(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := readTar(t, zr)
+	expected := map[string]string{
+		"app.log":          "fake logs",
+		"app.previous.log": "fake logs",
+		"sidecar.log":      "fake logs",
+	}
+	if fmt.Sprint(files) != fmt.Sprint(expected) {
+		t.Fatalf("expected files %v, got %v", expected, files)
+	}
+
+	res, err = http.Get(fmt.Sprintf("http://%s/namespace/default/pod/web-0/logs/archive?container=app&compress=zstd", s.Addr()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if res.Header.Get("Content-Type") != ContentTypeZstd {
+		t.Fatalf("unexpected Content-Type:%s", res.Header.Get("Content-Type"))
+	}
+	zr2, err := zstd.NewReader(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zr2.Close()
+	if files = readTar(t, zr2); fmt.Sprint(files) != fmt.Sprint(map[string]string{"app.log": "fake logs", "app.previous.log": "fake logs"}) {
+		t.Fatalf("unexpected zstd files %v", files)
+	}
+
+	var e HttpResponse
+	getJSON(t, fmt.Sprintf("http://%s/namespace/default/pod/web-0/logs/archive?container=db", s.Addr()), &e)
+	if e.Code != CodeError {
+		t.Fatalf("expected an error for the unknown container, got %+v", e)
+	}
+}
+
+func TestLogArchiveMaxDownloadBytes(t *testing.T) {
+	pod := newRunningPod("web-0", nil, "app", "sidecar")
+	// a tar entry of the fake logs takes 1024 bytes
+	s, _ := newFakeServerWithOptions(t, &ServerOptions{DrainTimeout: time.Millisecond * 100, MaxDownloadBytes: 1024}, pod)
+	defer s.ShutDown()
+
+	// the limit was hit once the second entry was written after the first one, the response must be aborted
+	res, err := http.Get(fmt.Sprintf("http://%s/namespace/default/pod/web-0/logs/archive?compress=none", s.Addr()))
+	if err == nil {
+		_, err = ioutil.ReadAll(res.Body)
+		res.Body.Close()
+	}
+	if err == nil {
+		t.Fatal("expected the archive over the limit was aborted")
+	}
+
+	// the limit was hit by the first tar header before anything was sent
+	s2, _ := newFakeServerWithOptions(t, &ServerOptions{DrainTimeout: time.Millisecond * 100, MaxDownloadBytes: 100}, pod)
+	defer s2.ShutDown()
+	var e HttpResponse
+	getJSON(t, fmt.Sprintf("http://%s/namespace/default/pod/web-0/logs/archive?compress=none", s2.Addr()), &e)
+	if e.Code != CodeError || e.Message != fmt.Sprintf(ErrDownloadLimitExceeded, 100) {
+		t.Fatalf("expected an error for the archive over the limit, got %+v", e)
+	}
+}
+
+func TestLogDownloadGzip(t *testing.T) {
+	s, _ := newFakeServer(t, time.Millisecond*100, newRunningPod("web-0", nil, "app"))
+	defer s.ShutDown()
+	u := fmt.Sprintf("http://%s/namespace/default/pod/web-0/container/app/previous/false/sinceSeconds/0/sinceTime/0", s.Addr())
+
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// setting the header disables the transparent decompression of the transport
+	req.Header.Set("Accept-Encoding", "gzip")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if res.Header.Get("Content-Encoding") != "gzip" {
+		t.Fatalf("unexpected Content-Encoding:%s", res.Header.Get("Content-Encoding"))
+	}
+	zr, err := gzip.NewReader(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "fake logs" {
+		t.Fatalf("unexpected logs %q", data)
+	}
+
+	res, err = http.Get(u + "?compress=zstd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if res.Header.Get("Content-Type") != ContentTypeZstd {
+		t.Fatalf("unexpected Content-Type:%s", res.Header.Get("Content-Type"))
+	}
+	zr2, err := zstd.NewReader(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zr2.Close()
+	if data, err = ioutil.ReadAll(zr2); err != nil || string(data) != "fake logs" {
+		t.Fatalf("unexpected zstd logs %q err:%v", data, err)
+	}
+
+	req.Header.Set("Accept-Encoding", "gzip;q=0.8, zstd")
+	if res, err = http.DefaultClient.Do(req); err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if res.Header.Get("Content-Encoding") != "zstd" {
+		t.Fatalf("unexpected Content-Encoding:%s", res.Header.Get("Content-Encoding"))
+	}
+	zr3, err := zstd.NewReader(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zr3.Close()
+	if data, err = ioutil.ReadAll(zr3); err != nil || string(data) != "fake logs" {
+		t.Fatalf("unexpected zstd encoded logs %q err:%v", data, err)
+	}
+}
+
+func TestLogArchiveWithoutSpooling(t *testing.T) {
+	// a temp file could not be created under the missing TMPDIR
+	tmpdir := os.Getenv("TMPDIR")
+	defer os.Setenv("TMPDIR", tmpdir)
+	if err := os.Setenv("TMPDIR", "/nonexistent/k8s-exec-pod"); err != nil {
+		t.Fatal(err)
+	}
+	k8sClient := fake.NewSimpleClientset()
+	pod := newRunningPod("web-0", nil, "app")
+	sinceSeconds := int64(60)
+	var buf bytes.Buffer
+	if err := writeTarArchive(&buf, CompressionNone, 0, func(tw *tar.Writer) error {
+		return WriteLogArchive(context.Background(), k8sClient, &ExecOptions{Namespace: "default", SinceSeconds: &sinceSeconds}, pod, false, 0, "", tw)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if files := readTar(t, &buf); files["app.log"] != "fake logs" {
+		t.Fatalf("unexpected files %v", files)
+	}
+}
@@ -0,0 +1,220 @@
+package k8s_exec_pod
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/websocket"
+	"sync"
+	"time"
+)
+
+// LogDropPolicy decides which frames would be dropped once the buffer of a log session was full
+type LogDropPolicy string
+
+const (
+	// LogDropOldest drops the oldest buffered frames for the new ones
+	LogDropOldest LogDropPolicy = "oldest"
+	// LogDropNotify drops the new frames, and tells the client how many frames were skipped by a ControlLogDropped
+	// message where they were skipped, i.e. before the next frame queued after them, or at the end of the session
+	LogDropNotify LogDropPolicy = "notify"
+)
+
+const (
+	ErrLogDropPolicyNotSupported = "error: the log drop policy:%v was not supported"
+)
+
+// DefaultLogBufferBytes is the buffer size of a log session if ServerOptions.LogBufferBytes was not positive
+const DefaultLogBufferBytes = 4 << 20
+
+func parseLogDropPolicy(policy string) (LogDropPolicy, error) {
+	switch p := LogDropPolicy(policy); p {
+	case "":
+		return LogDropOldest, nil
+	case LogDropOldest, LogDropNotify:
+		return p, nil
+	default:
+		return "", fmt.Errorf(ErrLogDropPolicyNotSupported, policy)
+	}
+}
+
+// logBuffer decouples the log streams from the websocket. The frames are queued without blocking the streams,
+// and sent in order by run while the buffer was not paused. Once more than max bytes were queued, the output frames
+// would be dropped by the policy. An output frame is a line unless the LogFormat was empty.
+// The control frames are queued in order with the output and never dropped, but they are sent at once while paused.
+// The frames skipped by LogDropNotify are counted by dropped until the next frame was queued, which carries the count.
+type logBuffer struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	frames  []*logFrame
+	size    int
+	max     int
+	policy  LogDropPolicy
+	paused  bool
+	closed  bool
+	dropped int64
+
+	send func(messageType int, data []byte) error
+	done chan struct{}
+}
+
+// logFrame is a queued frame, dropped is the count of the frames skipped right before it
+type logFrame struct {
+	message
+	dropped int64
+}
+
+func newLogBuffer(max int, policy LogDropPolicy, send func(messageType int, data []byte) error) *logBuffer {
+	if max <= 0 {
+		max = DefaultLogBufferBytes
+	}
+	if policy == "" {
+		policy = LogDropOldest
+	}
+	b := &logBuffer{
+		max:    max,
+		policy: policy,
+		send:   send,
+		done:   make(chan struct{}),
+	}
+	b.cond = sync.NewCond(&b.mu)
+	return b
+}
+
+// push queues the output frame without blocking, it fails once the buffer was closed.
+// A frame larger than max is dropped by either policy instead of being queued, so that the size never exceeded max.
+func (b *logBuffer) push(data []byte) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return fmt.Errorf("error: the log buffer was closed")
+	}
+	if b.policy == LogDropNotify {
+		if b.size+len(data) > b.max {
+			b.dropped++
+			return nil
+		}
+	} else if len(data) > b.max {
+		return nil
+	} else {
+		for i := 0; b.size+len(data) > b.max && i < len(b.frames); {
+			if b.frames[i].messageType != websocket.BinaryMessage {
+				i++
+				continue
+			}
+			b.size -= len(b.frames[i].data)
+			if i == 0 {
+				b.frames[0] = nil
+				b.frames = b.frames[1:]
+			} else {
+				b.frames = append(b.frames[:i], b.frames[i+1:]...)
+			}
+		}
+	}
+	b.enqueue(websocket.BinaryMessage, data)
+	b.size += len(data)
+	return nil
+}
+
+// enqueue appends the frame with the count of the frames skipped before it, b.mu must be held
+func (b *logBuffer) enqueue(messageType int, data []byte) {
+	b.frames = append(b.frames, &logFrame{message: message{messageType: messageType, data: data}, dropped: b.dropped})
+	b.dropped = 0
+	b.cond.Signal()
+}
+
+// pushControl queues the control frame, or sends it at once while paused
+func (b *logBuffer) pushControl(data []byte) error {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return fmt.Errorf("error: the log buffer was closed")
+	}
+	if b.paused {
+		b.mu.Unlock()
+		return b.send(websocket.TextMessage, data)
+	}
+	b.enqueue(websocket.TextMessage, data)
+	b.mu.Unlock()
+	return nil
+}
+
+func (b *logBuffer) setPaused(paused bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.paused = paused
+	b.cond.Signal()
+}
+
+// run sends the queued frames until the buffer was closed and drained, or the send failed
+func (b *logBuffer) run() {
+	defer close(b.done)
+	for {
+		b.mu.Lock()
+		for (len(b.frames) == 0 || b.paused) && !b.closed {
+			b.cond.Wait()
+		}
+		if len(b.frames) == 0 {
+			// the frames skipped after the last one were told at the end
+			dropped := b.dropped
+			b.dropped = 0
+			b.mu.Unlock()
+			if dropped > 0 {
+				_ = b.sendDropped(dropped)
+			}
+			return
+		}
+		frame := b.frames[0]
+		b.frames[0] = nil
+		b.frames = b.frames[1:]
+		if frame.messageType == websocket.BinaryMessage {
+			b.size -= len(frame.data)
+		}
+		b.mu.Unlock()
+		if frame.dropped > 0 {
+			if err := b.sendDropped(frame.dropped); err != nil {
+				b.abort()
+				return
+			}
+		}
+		if err := b.send(frame.messageType, frame.data); err != nil {
+			b.abort()
+			return
+		}
+	}
+}
+
+// sendDropped sends the ControlLogDropped message of the skipped frames
+func (b *logBuffer) sendDropped(dropped int64) error {
+	data, err := json.Marshal(&ControlMsg{
+		MsgType: ControlLogDropped,
+		Message: fmt.Sprintf("%d frames were skipped", dropped),
+		Count:   dropped,
+	})
+	if err != nil {
+		return err
+	}
+	return b.send(websocket.TextMessage, data)
+}
+
+// abort closes the buffer and drops the queued frames
+func (b *logBuffer) abort() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	b.frames = nil
+	b.size = 0
+}
+
+// close stops accepting the frames and waits up to the timeout for the queued ones to be sent,
+// a paused buffer would be resumed for the flush
+func (b *logBuffer) close(timeout time.Duration) {
+	b.mu.Lock()
+	b.closed = true
+	b.paused = false
+	b.cond.Signal()
+	b.mu.Unlock()
+	select {
+	case <-b.done:
+	case <-time.After(timeout):
+	}
+}
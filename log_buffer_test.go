@@ -0,0 +1,173 @@
+package k8s_exec_pod
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/websocket"
+	"sync"
+	"testing"
+	"time"
+)
+
+type recordedFrames struct {
+	mu     sync.Mutex
+	frames []string
+}
+
+func (r *recordedFrames) send(messageType int, data []byte) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if messageType == websocket.TextMessage {
+		var msg ControlMsg
+		if err := json.Unmarshal(data, &msg); err != nil {
+			return err
+		}
+		r.frames = append(r.frames, fmt.Sprintf("%s:%d", msg.MsgType, msg.Count))
+		return nil
+	}
+	r.frames = append(r.frames, string(data))
+	return nil
+}
+
+func (r *recordedFrames) String() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return fmt.Sprint(r.frames)
+}
+
+func TestLogBufferDropPolicy(t *testing.T) {
+	cases := []struct {
+		policy LogDropPolicy
+		want   string
+	}{
+		{LogDropOldest, "[c d]"},
+		{LogDropNotify, "[a b log_dropped:2]"},
+	}
+	for _, c := range cases {
+		var r recordedFrames
+		b := newLogBuffer(2, c.policy, r.send)
+		b.setPaused(true)
+		go b.run()
+		for _, frame := range []string{"a", "b", "c", "d"} {
+			if err := b.push([]byte(frame)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		b.close(time.Second)
+		if got := r.String(); got != c.want {
+			t.Fatalf("policy:%s want %s, got %s", c.policy, c.want, got)
+		}
+		if err := b.push([]byte("e")); err == nil {
+			t.Fatal("expected an error after the buffer was closed")
+		}
+	}
+}
+
+func TestLogBufferPauseResume(t *testing.T) {
+	var r recordedFrames
+	b := newLogBuffer(0, "", r.send)
+	go b.run()
+	b.setPaused(true)
+	for _, frame := range []string{"a", "b"} {
+		if err := b.push([]byte(frame)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(time.Millisecond * 50)
+	if got := r.String(); got != "[]" {
+		t.Fatalf("expected nothing to be sent while paused, got %s", got)
+	}
+	b.setPaused(false)
+	deadline := time.Now().Add(time.Second)
+	for r.String() != "[a b]" {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the frames to be sent after resumed, got %s", r.String())
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	b.close(time.Second)
+}
+
+func TestLogBufferControl(t *testing.T) {
+	var r recordedFrames
+	b := newLogBuffer(2, LogDropOldest, r.send)
+	// queued in order with the output and never dropped
+	for _, frame := range []string{"a", `{"type":"log_stream_stopped"}`, "b", "c"} {
+		var err error
+		if frame[0] == '{' {
+			err = b.pushControl([]byte(frame))
+		} else {
+			err = b.push([]byte(frame))
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// sent at once while paused
+	b.setPaused(true)
+	if err := b.pushControl([]byte(`{"type":"server_restarting"}`)); err != nil {
+		t.Fatal(err)
+	}
+	go b.run()
+	b.close(time.Second)
+	if got, want := r.String(), "[server_restarting:0 log_stream_stopped:0 b c]"; got != want {
+		t.Fatalf("want %s, got %s", want, got)
+	}
+}
+
+func TestLogBufferDropNotifyGap(t *testing.T) {
+	var r recordedFrames
+	b := newLogBuffer(2, LogDropNotify, r.send)
+	b.setPaused(true)
+	go b.run()
+	// c and d were skipped after a and b, the notice must precede e which followed the gap
+	for _, frame := range []string{"a", "b", "c", "d"} {
+		if err := b.push([]byte(frame)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	b.setPaused(false)
+	deadline := time.Now().Add(time.Second)
+	for r.String() != "[a b]" {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the queued frames to be sent, got %s", r.String())
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	if err := b.push([]byte("e")); err != nil {
+		t.Fatal(err)
+	}
+	b.close(time.Second)
+	if got, want := r.String(), "[a b log_dropped:2 e]"; got != want {
+		t.Fatalf("want %s, got %s", want, got)
+	}
+}
+
+func TestLogBufferOversizedFrame(t *testing.T) {
+	for _, policy := range []LogDropPolicy{LogDropOldest, LogDropNotify} {
+		var r recordedFrames
+		b := newLogBuffer(4, policy, r.send)
+		b.setPaused(true)
+		go b.run()
+		// the oversized frame must be dropped even into the empty buffer
+		for _, frame := range []string{"abcdef", "ab", "abcde"} {
+			if err := b.push([]byte(frame)); err != nil {
+				t.Fatal(err)
+			}
+			b.mu.Lock()
+			size := b.size
+			b.mu.Unlock()
+			if size > b.max {
+				t.Fatalf("policy:%s the size:%d exceeded the max:%d after %q", policy, size, b.max, frame)
+			}
+		}
+		b.close(time.Second)
+		want := "[ab]"
+		if policy == LogDropNotify {
+			want = "[log_dropped:1 ab log_dropped:1]"
+		}
+		if got := r.String(); got != want {
+			t.Fatalf("policy:%s want %s, got %s", policy, want, got)
+		}
+	}
+}
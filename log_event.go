@@ -0,0 +1,405 @@
+package k8s_exec_pod
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/Shanghai-Lunara/pkg/zaplogger"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// LogMarkerEvent marks the line of a Kubernetes Event
+	LogMarkerEvent = "event"
+)
+
+// LogEvent is the Event of a LogMarkerEvent line in LogFormatJSON, LogLine.Raw is the message of the Event
+type LogEvent struct {
+	// Type is Normal or Warning
+	Type   string `json:"type"`
+	Reason string `json:"reason"`
+	// Kind and Name are the involved object, e.g. Pod/web-0 or Deployment/web
+	Kind  string `json:"kind"`
+	Name  string `json:"name"`
+	Count int32  `json:"count,omitempty"`
+}
+
+// eventContainerRegexp matches the container of the InvolvedObject.FieldPath, e.g. `spec.containers{app}`
+var eventContainerRegexp = regexp.MustCompile(`^spec\.(?:initContainers|containers|ephemeralContainers)\{(.+)\}$`)
+
+// EventTransmit is called from Session instead of LogTransmit if ExecOptions.Events was set.
+// It streams the recent Events of the pod, or of the pods matching ExecOptions.Selector, as LogMarkerEvent lines
+// until the session was closed. The Events of the workload of ExecOptions.WorkloadKind are streamed as well.
+func EventTransmit(k8sClient kubernetes.Interface, session Session) error {
+	if err := watchEvents(k8sClient, session); err != nil {
+		zaplogger.Sugar().Error(err)
+		session.Close(err.Error())
+		return err
+	}
+	return nil
+}
+
+// workloadObjectKinds are the kinds of the workloads in the InvolvedObject of their Events
+var workloadObjectKinds = map[WorkloadKind]string{
+	WorkloadDeployment:  "Deployment",
+	WorkloadStatefulSet: "StatefulSet",
+	WorkloadDaemonSet:   "DaemonSet",
+	WorkloadJob:         "Job",
+}
+
+// watchEvents lists and then watches the Events of the session until the session was done, the watches are reopened
+// once they were closed by the apiserver. The Events are selected by the field selectors of their InvolvedObject,
+// the Events of the pods of ExecOptions.Selector are matched by the names of the pods, which are tracked by a watch
+// of the pods matching the selector.
+func watchEvents(k8sClient kubernetes.Interface, session Session) error {
+	opt := session.Option()
+	ctx, cancel := context.WithCancel(session.Ctx())
+	defer cancel()
+	m := &eventMatcher{session: session, events: k8sClient.CoreV1().Events(opt.Namespace)}
+	podEvents := fields.Set{"involvedObject.kind": "Pod"}
+	if opt.Selector != "" {
+		selector, err := labels.Parse(opt.Selector)
+		if err != nil {
+			return err
+		}
+		m.pods = &podNames{pods: k8sClient.CoreV1().Pods(opt.Namespace), selector: selector}
+		if err = m.pods.list(ctx); err != nil {
+			return err
+		}
+	} else {
+		podEvents["involvedObject.name"] = opt.PodName
+	}
+	streams := []*eventStream{{fieldSelector: podEvents.String()}}
+	if opt.WorkloadKind != "" {
+		kind, ok := workloadObjectKinds[opt.WorkloadKind]
+		if !ok {
+			return fmt.Errorf(ErrWorkloadKindNotSupported, opt.WorkloadKind)
+		}
+		streams = append(streams, &eventStream{
+			fieldSelector: fields.Set{"involvedObject.kind": kind, "involvedObject.name": opt.WorkloadName}.String(),
+		})
+	}
+	// the listed Events of all the streams are sent the oldest first
+	listed := make([]streamEvent, 0)
+	for _, st := range streams {
+		items, err := m.list(ctx, st)
+		if err != nil {
+			return ignoreDone(ctx, err)
+		}
+		listed = append(listed, items...)
+	}
+	if err := m.sendSorted(listed); err != nil {
+		return err
+	}
+
+	errCh := make(chan error, len(streams)+1)
+	running := 0
+	run := func(fn func(ctx context.Context) error) {
+		running++
+		go func() {
+			err := fn(ctx)
+			if err != nil {
+				// the failure of a watch stops the others
+				cancel()
+			}
+			errCh <- err
+		}()
+	}
+	if m.pods != nil {
+		run(m.pods.track)
+	}
+	for _, st := range streams {
+		st := st
+		run(func(ctx context.Context) error {
+			return m.track(ctx, st)
+		})
+	}
+	var res error
+	for ; running > 0; running-- {
+		if err := <-errCh; err != nil && res == nil {
+			res = err
+		}
+	}
+	return res
+}
+
+// ignoreDone returns nil instead of the error once the ctx was done
+func ignoreDone(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return nil
+	}
+	return err
+}
+
+// eventStream lists and watches the Events of the field selector
+type eventStream struct {
+	fieldSelector   string
+	resourceVersion string
+	// sent are the resource versions of the Events which were sent, the Events are not sent twice after a re-list.
+	// It was only accessed by the goroutine of the stream.
+	sent map[types.UID]string
+}
+
+// streamEvent is a listed Event of the stream
+type streamEvent struct {
+	st *eventStream
+	e  *corev1.Event
+}
+
+type eventMatcher struct {
+	session Session
+	events  corev1client.EventInterface
+	// pods are the names of the pods matching the selector, nil if the session had no selector
+	pods *podNames
+}
+
+// list lists the Events of the stream, the sent ones which were gone are evicted
+func (m *eventMatcher) list(ctx context.Context, st *eventStream) ([]streamEvent, error) {
+	list, err := m.events.List(ctx, metav1.ListOptions{FieldSelector: st.fieldSelector})
+	if err != nil {
+		return nil, err
+	}
+	st.resourceVersion = list.ResourceVersion
+	res := make([]streamEvent, 0, len(list.Items))
+	sent := make(map[types.UID]string, len(list.Items))
+	for i := range list.Items {
+		e := &list.Items[i]
+		if v, ok := st.sent[e.UID]; ok {
+			sent[e.UID] = v
+		}
+		res = append(res, streamEvent{st: st, e: e})
+	}
+	st.sent = sent
+	return res, nil
+}
+
+// sendSorted sends the listed Events the oldest first
+func (m *eventMatcher) sendSorted(events []streamEvent) error {
+	sort.SliceStable(events, func(i, j int) bool {
+		return eventTime(events[i].e).Before(eventTime(events[j].e))
+	})
+	for _, v := range events {
+		if err := m.send(v.st, v.e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// track watches the Events of the stream since the last list, and re-lists them once the watch was closed
+func (m *eventMatcher) track(ctx context.Context, st *eventStream) error {
+	for {
+		w, err := m.events.Watch(ctx, metav1.ListOptions{FieldSelector: st.fieldSelector, ResourceVersion: st.resourceVersion})
+		if err != nil {
+			return ignoreDone(ctx, err)
+		}
+		if err = m.watch(ctx, st, w); err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(podWatchRetryInterval):
+		}
+		events, err := m.list(ctx, st)
+		if err != nil {
+			return ignoreDone(ctx, err)
+		}
+		if err = m.sendSorted(events); err != nil {
+			return err
+		}
+	}
+}
+
+// watch sends the added and modified Events until the watch was closed or the ctx was done,
+// the deleted Events are evicted
+func (m *eventMatcher) watch(ctx context.Context, st *eventStream, w watch.Interface) error {
+	defer w.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event, ok := <-w.ResultChan():
+			if !ok {
+				return nil
+			}
+			e, ok := event.Object.(*corev1.Event)
+			if !ok {
+				continue
+			}
+			switch event.Type {
+			case watch.Added, watch.Modified:
+				if err := m.send(st, e); err != nil {
+					return err
+				}
+			case watch.Deleted:
+				delete(st.sent, e.UID)
+			}
+		}
+	}
+}
+
+// send writes the Event onto the session if it matched and was not sent yet
+func (m *eventMatcher) send(st *eventStream, e *corev1.Event) error {
+	if !m.match(e) {
+		return nil
+	}
+	if st.sent == nil {
+		st.sent = make(map[types.UID]string)
+	}
+	if st.sent[e.UID] == e.ResourceVersion && e.ResourceVersion != "" {
+		return nil
+	}
+	st.sent[e.UID] = e.ResourceVersion
+	if !m.session.LogFilter().Match([]byte(e.Message)) {
+		return nil
+	}
+	data, err := formatEventLine(m.session.Option().LogFormat, e)
+	if err != nil {
+		return err
+	}
+	_, err = m.session.Write(data)
+	return err
+}
+
+// match reports whether the Event was involving the pod of the session, a pod matching the selector,
+// or the workload of the session. The field selectors of the streams are checked again here.
+func (m *eventMatcher) match(e *corev1.Event) bool {
+	opt := m.session.Option()
+	obj := e.InvolvedObject
+	if obj.Kind != "Pod" {
+		return opt.WorkloadKind != "" && obj.Kind == workloadObjectKinds[opt.WorkloadKind] && obj.Name == opt.WorkloadName
+	}
+	if m.pods == nil {
+		return obj.Name == opt.PodName
+	}
+	return m.pods.has(obj.Name)
+}
+
+// podNames tracks the names of the pods matching the selector
+type podNames struct {
+	pods            corev1client.PodInterface
+	selector        labels.Selector
+	resourceVersion string
+
+	mu    sync.RWMutex
+	names map[string]bool
+}
+
+func (p *podNames) has(name string) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.names[name]
+}
+
+// list replaces the names by the pods matching the selector
+func (p *podNames) list(ctx context.Context) error {
+	list, err := p.pods.List(ctx, metav1.ListOptions{LabelSelector: p.selector.String()})
+	if err != nil {
+		return err
+	}
+	names := make(map[string]bool, len(list.Items))
+	for i := range list.Items {
+		if p.selector.Matches(labels.Set(list.Items[i].Labels)) {
+			names[list.Items[i].Name] = true
+		}
+	}
+	p.mu.Lock()
+	p.names = names
+	p.mu.Unlock()
+	p.resourceVersion = list.ResourceVersion
+	return nil
+}
+
+// track watches the pods since the last list, the deleted pods and the ones which no longer matched the selector
+// are evicted. The pods are re-listed once the watch was closed.
+func (p *podNames) track(ctx context.Context) error {
+	for {
+		w, err := p.pods.Watch(ctx, metav1.ListOptions{LabelSelector: p.selector.String(), ResourceVersion: p.resourceVersion})
+		if err != nil {
+			return ignoreDone(ctx, err)
+		}
+		p.watch(ctx, w)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(podWatchRetryInterval):
+		}
+		if err = p.list(ctx); err != nil {
+			return ignoreDone(ctx, err)
+		}
+	}
+}
+
+func (p *podNames) watch(ctx context.Context, w watch.Interface) {
+	defer w.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event, ok := <-w.ResultChan():
+			if !ok {
+				return
+			}
+			pod, ok := event.Object.(*corev1.Pod)
+			if !ok {
+				continue
+			}
+			p.mu.Lock()
+			if event.Type != watch.Deleted && p.selector.Matches(labels.Set(pod.Labels)) {
+				p.names[pod.Name] = true
+			} else {
+				delete(p.names, pod.Name)
+			}
+			p.mu.Unlock()
+		}
+	}
+}
+
+// formatEventLine frames the Event as a LogMarkerEvent line, e.g. `[web-0/app] --- event Warning BackOff: ... ---`
+func formatEventLine(format LogFormat, e *corev1.Event) ([]byte, error) {
+	var pod, container string
+	if e.InvolvedObject.Kind == "Pod" {
+		pod = e.InvolvedObject.Name
+		if res := eventContainerRegexp.FindStringSubmatch(e.InvolvedObject.FieldPath); res != nil {
+			container = res[1]
+		}
+	}
+	if format != LogFormatJSON {
+		text := fmt.Sprintf("event %s %s: %s", e.Type, e.Reason, e.Message)
+		if pod == "" {
+			pod = e.InvolvedObject.Kind
+			container = e.InvolvedObject.Name
+		}
+		return formatMarkerLine(format, pod, container, LogMarkerEvent, text)
+	}
+	data, err := json.Marshal(LogLine{
+		Timestamp: eventTime(e).UTC().Format(time.RFC3339Nano),
+		Pod:       pod,
+		Container: container,
+		Raw:       e.Message,
+		Marker:    LogMarkerEvent,
+		Event: &LogEvent{
+			Type:   e.Type,
+			Reason: e.Reason,
+			Kind:   e.InvolvedObject.Kind,
+			Name:   e.InvolvedObject.Name,
+			Count:  e.Count,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
@@ -0,0 +1,195 @@
+package k8s_exec_pod
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/websocket"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newPodEvent(name, pod, reason string, last time.Time) *corev1.Event {
+	return &corev1.Event{
+		ObjectMeta:     metav1.ObjectMeta{Name: name, Namespace: "default"},
+		InvolvedObject: corev1.ObjectReference{Kind: "Pod", Name: pod, FieldPath: "spec.containers{app}"},
+		Type:           corev1.EventTypeWarning,
+		Reason:         reason,
+		Message:        reason + " of " + pod,
+		LastTimestamp:  metav1.Time{Time: last},
+	}
+}
+
+func TestFormatEventLine(t *testing.T) {
+	e := newPodEvent("e", "web-0", "BackOff", time.Date(2021, 1, 1, 8, 0, 0, 0, time.UTC))
+	data, err := formatEventLine(LogFormatPrefix, e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "[web-0/app] --- event Warning BackOff: BackOff of web-0 ---\n"; string(data) != expected {
+		t.Fatalf("expected %q, got %q", expected, data)
+	}
+	data, err = formatEventLine(LogFormatJSON, e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"timestamp":"2021-01-01T08:00:00Z","pod":"web-0","container":"app","raw":"BackOff of web-0","marker":"event","event":{"type":"Warning","reason":"BackOff","kind":"Pod","name":"web-0"}}` + "\n"
+	if string(data) != expected {
+		t.Fatalf("expected %s, got %s", expected, data)
+	}
+	e.InvolvedObject = corev1.ObjectReference{Kind: "Deployment", Name: "web"}
+	if data, err = formatEventLine(LogFormatLine, e); err != nil || !strings.Contains(string(data), "--- event Warning BackOff") {
+		t.Fatalf("unexpected line %q err:%v", data, err)
+	}
+}
+
+func TestEventsStream(t *testing.T) {
+	now := time.Now()
+	s, _ := newFakeServer(t, time.Millisecond*100,
+		newRunningPod("web-0", map[string]string{"app": "web"}, "app"),
+		newRunningPod("db-0", map[string]string{"app": "db"}, "db"),
+		newPodEvent("web-0.2", "web-0", "BackOff", now),
+		newPodEvent("web-0.1", "web-0", "Pulled", now.Add(-time.Minute)),
+		newPodEvent("db-0.1", "db-0", "Killing", now),
+	)
+	defer s.ShutDown()
+
+	var res HttpResponse
+	getJSON(t, fmt.Sprintf("http://%s/cluster/%s/namespace/default/events?selector=app%%3Dweb", s.Addr(), fakeClusterName), &res)
+	if res.Code != CodeSuccess || res.Token == "" {
+		t.Fatalf("unexpected token response: %+v", res)
+	}
+	ws := dialLog(t, s, res.Token, "")
+	defer ws.Close()
+	reasons := make([]string, 0)
+	for len(reasons) < 2 {
+		messageType, data, err := ws.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if messageType != websocket.BinaryMessage {
+			continue
+		}
+		var line LogLine
+		if err = json.Unmarshal(data, &line); err != nil {
+			t.Fatalf("unexpected line %q err:%v", data, err)
+		}
+		if line.Marker != LogMarkerEvent || line.Event == nil || line.Pod != "web-0" {
+			t.Fatalf("unexpected line %s", data)
+		}
+		reasons = append(reasons, line.Event.Reason)
+	}
+	if fmt.Sprint(reasons) != "[Pulled BackOff]" {
+		t.Fatalf("expected the events of web-0 the oldest first, got %v", reasons)
+	}
+
+	getJSON(t, fmt.Sprintf("http://%s/namespace/default/events", s.Addr()), &res)
+	if res.Code != CodeError {
+		t.Fatalf("expected an error without the pod or the selector, got %+v", res)
+	}
+}
+
+func TestEventsStreamFieldSelector(t *testing.T) {
+	s, _ := newFakeServer(t, time.Millisecond*100,
+		newRunningPod("web-0", map[string]string{"app": "web"}, "app"),
+		newPodEvent("web-0.1", "web-0", "BackOff", time.Now()),
+	)
+	defer s.ShutDown()
+	cluster, err := s.clusters.Get(fakeClusterName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k8sClient := cluster.Client().(*fake.Clientset)
+
+	for query, expected := range map[string]string{
+		"pod=web-0":          "involvedObject.kind=Pod,involvedObject.name=web-0",
+		"selector=app%3Dweb": "involvedObject.kind=Pod",
+	} {
+		k8sClient.ClearActions()
+		var res HttpResponse
+		getJSON(t, fmt.Sprintf("http://%s/namespace/default/events?%s", s.Addr(), query), &res)
+		if res.Code != CodeSuccess {
+			t.Fatalf("unexpected token response: %+v", res)
+		}
+		ws := dialLog(t, s, res.Token, "")
+		if _, _, err = ws.ReadMessage(); err != nil {
+			t.Fatal(err)
+		}
+		ws.Close()
+		selectors := make([]string, 0)
+		for _, action := range k8sClient.Actions() {
+			if action.GetResource().Resource == "pods" && action.GetVerb() == "get" {
+				t.Fatalf("unexpected get of the pod by %s", query)
+			}
+			if list, ok := action.(k8stesting.ListAction); ok && action.GetResource().Resource == "events" {
+				selectors = append(selectors, list.GetListRestrictions().Fields.String())
+			}
+		}
+		if len(selectors) == 0 || selectors[0] != expected {
+			t.Fatalf("expected the events were listed by %s for %s, got %v", expected, query, selectors)
+		}
+	}
+}
+
+func TestPodNamesEviction(t *testing.T) {
+	k8sClient := fake.NewSimpleClientset(
+		newRunningPod("web-0", map[string]string{"app": "web"}, "app"),
+		newRunningPod("db-0", map[string]string{"app": "db"}, "db"),
+	)
+	pods := k8sClient.CoreV1().Pods("default")
+	p := &podNames{pods: pods, selector: labels.SelectorFromSet(labels.Set{"app": "web"})}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := p.list(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if !p.has("web-0") || p.has("db-0") {
+		t.Fatalf("unexpected names %v", p.names)
+	}
+	w := watch.NewFake()
+	go p.watch(ctx, w)
+	w.Add(newRunningPod("web-1", map[string]string{"app": "web"}, "app"))
+	w.Modify(newRunningPod("web-0", map[string]string{"app": "other"}, "app"))
+	w.Delete(newRunningPod("web-1", map[string]string{"app": "web"}, "app"))
+	w.Add(newRunningPod("web-2", map[string]string{"app": "web"}, "app"))
+	deadline := time.Now().Add(time.Second)
+	for !p.has("web-2") {
+		if time.Now().After(deadline) {
+			t.Fatal("the added pod was not tracked")
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if fmt.Sprint(p.names) != "map[web-2:true]" {
+		t.Fatalf("expected the deleted and the unmatched pods were evicted, got %v", p.names)
+	}
+}
+
+func TestEventStreamEviction(t *testing.T) {
+	st := &eventStream{sent: map[types.UID]string{"1": "10", "2": "20"}}
+	m := &eventMatcher{}
+	w := watch.NewFake()
+	done := make(chan error, 1)
+	go func() {
+		done <- m.watch(context.Background(), st, w)
+	}()
+	e := newPodEvent("e", "web-0", "BackOff", time.Now())
+	e.UID = "1"
+	w.Delete(e)
+	w.Stop()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(st.sent) != "map[2:20]" {
+		t.Fatalf("expected the deleted event was evicted, got %v", st.sent)
+	}
+}
@@ -0,0 +1,234 @@
+package k8s_exec_pod
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	ErrInvalidLogFilter = "error: invalid log filter:%v err:%v"
+)
+
+// LogFilterSpec is the filter of a log session, it could be set by the `include`, `exclude` and `field` queries
+// of the log stream, or be changed mid-stream by a TermFilter message
+type LogFilterSpec struct {
+	// Include keeps the lines matching the regex only
+	Include string `json:"include,omitempty"`
+	// Exclude drops the lines matching the regex
+	Exclude string `json:"exclude,omitempty"`
+	// Field compares a field of the JSON lines, e.g. `level>=warn` or `status=500`, the nested fields are joined by `.`.
+	// The lines which were not JSON or had no such field would be dropped
+	Field string `json:"field,omitempty"`
+}
+
+// LogFilter is the compiled LogFilterSpec, a nil LogFilter matches every line
+type LogFilter struct {
+	include *regexp.Regexp
+	exclude *regexp.Regexp
+	field   *fieldFilter
+}
+
+// maxLogLineBytes bounds the pending partial line, a longer one would be filtered as a whole line
+const maxLogLineBytes = 64 * 1024
+
+// fieldFilterRegexp parses the field filter into the key, the operator and the value
+var fieldFilterRegexp = regexp.MustCompile(`^\s*([\w.\-]+)\s*(>=|<=|!=|==|=|>|<)\s*([^\s=<>!].*?)\s*$`)
+
+// logLevels ranks the common level names, the aliases share the same rank
+var logLevels = map[string]int{
+	"trace": 0, "debug": 1, "info": 2, "notice": 2, "warn": 3, "warning": 3,
+	"error": 4, "err": 4, "critical": 5, "crit": 5, "fatal": 5, "panic": 6,
+}
+
+type fieldFilter struct {
+	path  []string
+	op    string
+	value string
+}
+
+// NewLogFilter compiles the spec, nil would be returned if the spec was empty
+func NewLogFilter(spec LogFilterSpec) (*LogFilter, error) {
+	if spec == (LogFilterSpec{}) {
+		return nil, nil
+	}
+	f := &LogFilter{}
+	var err error
+	if spec.Include != "" {
+		if f.include, err = regexp.Compile(spec.Include); err != nil {
+			return nil, fmt.Errorf(ErrInvalidLogFilter, spec.Include, err)
+		}
+	}
+	if spec.Exclude != "" {
+		if f.exclude, err = regexp.Compile(spec.Exclude); err != nil {
+			return nil, fmt.Errorf(ErrInvalidLogFilter, spec.Exclude, err)
+		}
+	}
+	if spec.Field != "" {
+		if f.field, err = parseFieldFilter(spec.Field); err != nil {
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+func parseFieldFilter(expr string) (*fieldFilter, error) {
+	m := fieldFilterRegexp.FindStringSubmatch(expr)
+	if m == nil {
+		return nil, fmt.Errorf(ErrInvalidLogFilter, expr, "the field filter must be like `level>=warn`")
+	}
+	op := m[2]
+	if op == "==" {
+		op = "="
+	}
+	return &fieldFilter{path: strings.Split(m[1], "."), op: op, value: m[3]}, nil
+}
+
+// Match reports whether the line without the line break should be kept
+func (f *LogFilter) Match(line []byte) bool {
+	if f == nil {
+		return true
+	}
+	if f.include != nil && !f.include.Match(line) {
+		return false
+	}
+	if f.exclude != nil && f.exclude.Match(line) {
+		return false
+	}
+	if f.field != nil && !f.field.match(line) {
+		return false
+	}
+	return true
+}
+
+func (ff *fieldFilter) match(line []byte) bool {
+	if i := bytes.IndexByte(line, '{'); i < 0 {
+		return false
+	} else if i > 0 {
+		// skip the timestamp prefix of PodLogOptions.Timestamps
+		line = line[i:]
+	}
+	var obj map[string]interface{}
+	if err := json.Unmarshal(line, &obj); err != nil {
+		return false
+	}
+	var v interface{} = obj
+	for _, key := range ff.path {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		if v, ok = m[key]; !ok {
+			return false
+		}
+	}
+	var actual string
+	switch t := v.(type) {
+	case string:
+		actual = t
+	case float64:
+		actual = strconv.FormatFloat(t, 'f', -1, 64)
+	case bool:
+		actual = strconv.FormatBool(t)
+	default:
+		return false
+	}
+	return compareField(actual, ff.op, ff.value)
+}
+
+// compareField compares the values as numbers, as log levels, or as strings in turn
+func compareField(actual, op, expected string) bool {
+	var cmp int
+	a, aErr := strconv.ParseFloat(actual, 64)
+	e, eErr := strconv.ParseFloat(expected, 64)
+	al, aOk := logLevels[strings.ToLower(actual)]
+	el, eOk := logLevels[strings.ToLower(expected)]
+	switch {
+	case aErr == nil && eErr == nil:
+		switch {
+		case a < e:
+			cmp = -1
+		case a > e:
+			cmp = 1
+		}
+	case aOk && eOk:
+		cmp = al - el
+	default:
+		cmp = strings.Compare(strings.ToLower(actual), strings.ToLower(expected))
+	}
+	switch op {
+	case "=":
+		return cmp == 0
+	case "!=":
+		return cmp != 0
+	case ">=":
+		return cmp >= 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	case "<":
+		return cmp < 0
+	}
+	return false
+}
+
+// logFilterWriter writes the lines kept by the LogFilter of the session into it.
+// The bytes are passed through as they were while no filter was set and no partial line was pending.
+type logFilterWriter struct {
+	session Session
+	mu      sync.Mutex
+	pending []byte
+}
+
+func (w *logFilterWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	filter := w.session.LogFilter()
+	if filter == nil && len(w.pending) == 0 {
+		return w.session.Write(p)
+	}
+	w.pending = append(w.pending, p...)
+	var out []byte
+	for {
+		i := bytes.IndexByte(w.pending, '\n')
+		if i < 0 {
+			break
+		}
+		if line := w.pending[:i]; filter.Match(line) {
+			out = append(out, w.pending[:i+1]...)
+		}
+		w.pending = w.pending[i+1:]
+	}
+	if len(w.pending) >= maxLogLineBytes {
+		if filter.Match(w.pending) {
+			out = append(out, w.pending...)
+		}
+		w.pending = w.pending[len(w.pending):]
+	}
+	if len(w.pending) == 0 {
+		w.pending = nil
+	}
+	if len(out) > 0 {
+		if _, err := w.session.Write(out); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// Flush writes the pending partial line if it was kept by the filter
+func (w *logFilterWriter) Flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.pending) == 0 || !w.session.LogFilter().Match(w.pending) {
+		return nil
+	}
+	_, err := w.session.Write(w.pending)
+	w.pending = nil
+	return err
+}
@@ -0,0 +1,113 @@
+package k8s_exec_pod
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/websocket"
+	"testing"
+	"time"
+)
+
+func TestLogFilterMatch(t *testing.T) {
+	cases := []struct {
+		spec LogFilterSpec
+		line string
+		want bool
+	}{
+		{LogFilterSpec{}, "anything", true},
+		{LogFilterSpec{Include: "err(or)?"}, "an error occurred", true},
+		{LogFilterSpec{Include: "err(or)?"}, "all good", false},
+		{LogFilterSpec{Exclude: "healthz"}, "GET /healthz 200", false},
+		{LogFilterSpec{Include: "GET", Exclude: "healthz"}, "GET /api 200", true},
+		{LogFilterSpec{Field: "level>=warn"}, `{"level":"error","msg":"boom"}`, true},
+		{LogFilterSpec{Field: "level>=warn"}, `{"level":"WARNING","msg":"hmm"}`, true},
+		{LogFilterSpec{Field: "level>=warn"}, `{"level":"info","msg":"ok"}`, false},
+		{LogFilterSpec{Field: "level>=warn"}, "plain text", false},
+		{LogFilterSpec{Field: "level>=warn"}, `2021-01-01T08:00:00.000000000Z {"level":"fatal"}`, true},
+		{LogFilterSpec{Field: "status>=500"}, `{"status":503}`, true},
+		{LogFilterSpec{Field: "status>=500"}, `{"status":404}`, false},
+		{LogFilterSpec{Field: "http.method = POST"}, `{"http":{"method":"post"}}`, true},
+		{LogFilterSpec{Field: "http.method!=POST"}, `{"http":{"method":"GET"}}`, true},
+		{LogFilterSpec{Field: "user==alice"}, `{"other":"alice"}`, false},
+	}
+	for _, c := range cases {
+		f, err := NewLogFilter(c.spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := f.Match([]byte(c.line)); got != c.want {
+			t.Fatalf("filter %+v match %q = %v, want %v", c.spec, c.line, got, c.want)
+		}
+	}
+}
+
+func TestNewLogFilterInvalid(t *testing.T) {
+	for _, spec := range []LogFilterSpec{{Include: "("}, {Exclude: "[a-"}, {Field: "level"}, {Field: ">=warn"}, {Field: "level>="}} {
+		if _, err := NewLogFilter(spec); err == nil {
+			t.Fatalf("expected an error for %+v", spec)
+		}
+	}
+}
+
+func dialLog(t *testing.T, s *Server, token, query string) *websocket.Conn {
+	u := fmt.Sprintf("ws://%s/cluster/%s/log/sinceSeconds/0/sinceTime/0/token/%s?%s", s.Addr(), fakeClusterName, token, query)
+	ws, _, err := websocket.DefaultDialer.Dial(u, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = ws.SetReadDeadline(time.Now().Add(time.Second * 5))
+	return ws
+}
+
+func TestLogStreamFilterQuery(t *testing.T) {
+	s, _ := newFakeServer(t, time.Millisecond*100)
+	defer s.ShutDown()
+
+	ws := dialLog(t, s, getToken(t, s), "include=fake")
+	defer ws.Close()
+	if _, data, err := ws.ReadMessage(); err != nil || string(data) != "fake logs" {
+		t.Fatalf("unexpected message %q err:%v", data, err)
+	}
+
+	ws = dialLog(t, s, getToken(t, s), "exclude=fake")
+	defer ws.Close()
+	if _, data, err := ws.ReadMessage(); !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+		t.Fatalf("expected the line to be filtered, got %q err:%v", data, err)
+	}
+
+	var res HttpResponse
+	getJSON(t, fmt.Sprintf("http://%s/cluster/%s/log/sinceSeconds/0/sinceTime/0/token/%s?include=(", s.Addr(), fakeClusterName, getToken(t, s)), &res)
+	if res.Code != CodeError {
+		t.Fatalf("expected an error for the invalid filter, got %+v", res)
+	}
+}
+
+func TestLogFilterMessage(t *testing.T) {
+	s, _ := newFakeServer(t, time.Millisecond*100)
+	defer s.ShutDown()
+	var res HttpResponse
+	getJSON(t, fmt.Sprintf("http://%s/cluster/%s/namespace/default/logs?selector=app%%3Dweb", s.Addr(), fakeClusterName), &res)
+	ws := dialLog(t, s, res.Token, "")
+	defer ws.Close()
+
+	for _, c := range []struct {
+		filter *LogFilterSpec
+		want   ControlMessageType
+	}{
+		{&LogFilterSpec{Field: "level>=warn"}, ControlLogFilterApplied},
+		{&LogFilterSpec{Include: "("}, ControlLogFilterFailed},
+		{nil, ControlLogFilterApplied},
+	} {
+		if err := ws.WriteJSON(TermMsg{MsgType: TermFilter, Filter: c.filter}); err != nil {
+			t.Fatal(err)
+		}
+		_, data, err := ws.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var msg ControlMsg
+		if err = json.Unmarshal(data, &msg); err != nil || msg.MsgType != c.want {
+			t.Fatalf("expected %s, got %q err:%v", c.want, data, err)
+		}
+	}
+}
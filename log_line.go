@@ -0,0 +1,171 @@
+package k8s_exec_pod
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// LogFormat decides how the lines of a log session were framed. An empty format copies the bytes of the stream
+// as they were for a single container, and falls back to LogFormatPrefix for an aggregated log session.
+type LogFormat string
+
+const (
+	// LogFormatLine sends every complete line in its own frame
+	LogFormatLine LogFormat = "line"
+	// LogFormatPrefix prefixes every line with `[pod/container] `
+	LogFormatPrefix LogFormat = "prefix"
+	// LogFormatJSON sends every line as a LogLine
+	LogFormatJSON LogFormat = "json"
+)
+
+const (
+	ErrLogFormatNotSupported = "error: the log format:%v was not supported"
+)
+
+// LogLine is the envelope of a line of the log for LogFormatJSON
+type LogLine struct {
+	// Timestamp is the RFC3339 timestamp of the line which was added by PodLogOptions.Timestamps
+	Timestamp string `json:"timestamp,omitempty"`
+	Pod       string `json:"pod"`
+	Container string `json:"container"`
+	// Raw is the line without the timestamp and the line break
+	Raw string `json:"raw"`
+	// Parsed is the object of the Raw if it was a JSON line
+	Parsed json.RawMessage `json:"parsed,omitempty"`
+	// Marker is set for the lines which were generated by the server, e.g. LogMarkerRestart
+	Marker string `json:"marker,omitempty"`
+	// Event is set for the LogMarkerEvent lines
+	Event *LogEvent `json:"event,omitempty"`
+}
+
+const (
+	// LogMarkerRestart marks the line which was emitted once the container was restarted
+	LogMarkerRestart = "restart"
+)
+
+func parseLogFormat(format string) (LogFormat, error) {
+	switch f := LogFormat(format); f {
+	case "", LogFormatLine, LogFormatPrefix, LogFormatJSON:
+		return f, nil
+	default:
+		return "", fmt.Errorf(ErrLogFormatNotSupported, format)
+	}
+}
+
+// formatLogLine frames the line without the line break by the format,
+// the leading timestamp of the line would be split into LogLine.Timestamp if timestamps was true
+func formatLogLine(format LogFormat, timestamps bool, pod, container string, line []byte) ([]byte, error) {
+	switch format {
+	case LogFormatJSON:
+		res := LogLine{Pod: pod, Container: container}
+		if timestamps {
+			res.Timestamp, line = splitLogTimestamp(line)
+		}
+		res.Raw = string(line)
+		if trimmed := bytes.TrimSpace(line); len(trimmed) > 0 && trimmed[0] == '{' && json.Valid(trimmed) {
+			res.Parsed = trimmed
+		}
+		data, err := json.Marshal(res)
+		if err != nil {
+			return nil, err
+		}
+		return append(data, '\n'), nil
+	case LogFormatLine:
+		res := make([]byte, 0, len(line)+1)
+		return append(append(res, line...), '\n'), nil
+	default:
+		return []byte(fmt.Sprintf("[%s/%s] %s\n", pod, container, line)), nil
+	}
+}
+
+// splitLogTimestamp splits the RFC3339 timestamp from the line, the line would be returned as it was if it had none
+func splitLogTimestamp(line []byte) (string, []byte) {
+	i := bytes.IndexByte(line, ' ')
+	if i < 0 {
+		i = len(line)
+	}
+	ts := string(line[:i])
+	if _, err := time.Parse(time.RFC3339Nano, ts); err != nil {
+		return "", line
+	}
+	if i < len(line) {
+		i++
+	}
+	return ts, line[i:]
+}
+
+// transmitLines copies the stream of the container of the option onto the session line by line until EOF,
+// the lines are filtered by the LogFilter of the session and framed by ExecOptions.LogFormat.
+// A line longer than maxLogLineBytes would be split. The session would be closed if the write failed.
+// If the stream was requested with the timestamps, the timestamp of the last line would be returned, the lines
+// which were not after the time `after` would be skipped, and the timestamps would be stripped unless
+// ExecOptions.Timestamps was set.
+func transmitLines(session Session, opt *ExecOptions, r io.Reader, timestamps bool, after time.Time) (last string, err error) {
+	br := bufio.NewReaderSize(r, maxLogLineBytes)
+	for {
+		line, err := br.ReadSlice('\n')
+		if err == bufio.ErrBufferFull {
+			err = nil
+		}
+		if len(line) > 0 {
+			line = bytes.TrimSuffix(line, []byte("\n"))
+			if timestamps {
+				if ts, content := splitLogTimestamp(line); ts != "" {
+					if t, _ := time.Parse(time.RFC3339Nano, ts); !after.IsZero() && !t.After(after) {
+						// the line was sent before reopening the stream
+						if err != nil {
+							return last, nilIfEOF(err)
+						}
+						continue
+					}
+					last = ts
+					if !opt.Timestamps {
+						line = content
+					}
+				}
+			}
+			if session.LogFilter().Match(line) {
+				data, ferr := formatLogLine(opt.LogFormat, opt.Timestamps, opt.PodName, opt.ContainerName, line)
+				if ferr != nil {
+					return last, ferr
+				}
+				if _, werr := session.Write(data); werr != nil {
+					session.Close(werr.Error())
+					return last, werr
+				}
+			}
+		}
+		if err != nil {
+			return last, nilIfEOF(err)
+		}
+	}
+}
+
+func nilIfEOF(err error) error {
+	if err == io.EOF {
+		return nil
+	}
+	return err
+}
+
+// formatMarkerLine frames a line which was generated by the server, e.g. the restart of the container
+func formatMarkerLine(format LogFormat, pod, container, marker, text string) ([]byte, error) {
+	if format == LogFormatJSON {
+		data, err := json.Marshal(LogLine{
+			Timestamp: time.Now().UTC().Format(time.RFC3339Nano),
+			Pod:       pod,
+			Container: container,
+			Raw:       text,
+			Marker:    marker,
+		})
+		if err != nil {
+			return nil, err
+		}
+		return append(data, '\n'), nil
+	}
+	return formatLogLine(format, false, pod, container, []byte(fmt.Sprintf("--- %s ---", text)))
+}
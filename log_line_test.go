@@ -0,0 +1,65 @@
+package k8s_exec_pod
+
+import (
+	"fmt"
+	"github.com/gorilla/websocket"
+	"testing"
+	"time"
+)
+
+func TestFormatLogLine(t *testing.T) {
+	cases := []struct {
+		format     LogFormat
+		timestamps bool
+		line       string
+		want       string
+	}{
+		{"", false, "hello", "[web-0/app] hello\n"},
+		{LogFormatPrefix, false, "hello", "[web-0/app] hello\n"},
+		{LogFormatLine, true, "2021-01-01T08:00:00.123456789Z hello", "2021-01-01T08:00:00.123456789Z hello\n"},
+		{LogFormatJSON, false, "hello", `{"pod":"web-0","container":"app","raw":"hello"}` + "\n"},
+		{LogFormatJSON, true, "2021-01-01T08:00:00.123456789Z hello",
+			`{"timestamp":"2021-01-01T08:00:00.123456789Z","pod":"web-0","container":"app","raw":"hello"}` + "\n"},
+		{LogFormatJSON, true, `2021-01-01T08:00:00Z {"level":"info","msg":"ok"}`,
+			`{"timestamp":"2021-01-01T08:00:00Z","pod":"web-0","container":"app","raw":"{\"level\":\"info\",\"msg\":\"ok\"}","parsed":{"level":"info","msg":"ok"}}` + "\n"},
+		{LogFormatJSON, true, `no timestamp {"level":"info"`,
+			`{"pod":"web-0","container":"app","raw":"no timestamp {\"level\":\"info\""}` + "\n"},
+	}
+	for _, c := range cases {
+		data, err := formatLogLine(c.format, c.timestamps, "web-0", "app", []byte(c.line))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != c.want {
+			t.Fatalf("format:%q line:%q\nwant %s\ngot  %s", c.format, c.line, c.want, data)
+		}
+	}
+}
+
+func TestLogStreamLineFormat(t *testing.T) {
+	s, _ := newFakeServer(t, time.Millisecond*100)
+	defer s.ShutDown()
+
+	ws := dialLog(t, s, getToken(t, s), "format=json")
+	defer ws.Close()
+	messageType, data, err := ws.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"pod":"pod-0","container":"app","raw":"fake logs"}` + "\n"
+	if messageType != websocket.BinaryMessage || string(data) != want {
+		t.Fatalf("unexpected message type:%d data:%q", messageType, data)
+	}
+
+	var res HttpResponse
+	getJSON(t, fmt.Sprintf("http://%s/cluster/%s/log/sinceSeconds/0/sinceTime/0/token/%s?format=xml", s.Addr(), fakeClusterName, getToken(t, s)), &res)
+	if res.Code != CodeError {
+		t.Fatalf("expected an error for the invalid format, got %+v", res)
+	}
+
+	res = HttpResponse{}
+	getJSON(t, fmt.Sprintf("http://%s/cluster/%s/log/sinceSeconds/0/sinceTime/0/token/%s?format=json&timestamps=false", s.Addr(), fakeClusterName, getToken(t, s)), &res)
+	if res.Code != CodeError || res.Message != ErrLogFormatJSONWithoutTimestamps {
+		t.Fatalf("expected an error for the json format without timestamps, got %+v", res)
+	}
+}
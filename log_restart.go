@@ -0,0 +1,198 @@
+package k8s_exec_pod
+
+import (
+	"context"
+	"fmt"
+	"github.com/Shanghai-Lunara/pkg/zaplogger"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	"time"
+)
+
+// logReconnectInterval is the min interval of reopening the log stream of a container
+const logReconnectInterval = time.Second
+
+// logRestartSettleTimeout bounds how long the status of the container is re-read for a restart once the stream
+// ended, since the kubelet reports the new container some time after its log was available. The stream of the same
+// container would be reopened once the status was not changed within it.
+const logRestartSettleTimeout = logReconnectInterval * 3
+
+// logTransmitAcrossRestarts is LogTransmit for ExecOptions.FollowRestarts. Once the stream ended, it watches the pod
+// until the container was running again, and reopens the stream since the timestamp of the last received line.
+// A LogMarkerRestart line would be emitted before the stream of the restarted container was reopened. The session
+// would be closed once the pod was deleted or completed.
+func logTransmitAcrossRestarts(k8sClient kubernetes.Interface, session Session) error {
+	opt := session.Option()
+	ctx := session.Ctx()
+	pod, err := k8sClient.CoreV1().Pods(opt.Namespace).Get(ctx, opt.PodName, metav1.GetOptions{})
+	if err != nil {
+		zaplogger.Sugar().Error(err)
+		session.Close(err.Error())
+		return err
+	}
+	if opt.ContainerName == "" {
+		opt.ContainerName = DefaultContainer(pod)
+	}
+	var containerID string
+	var restartCount int32
+	if status := containerStatus(pod, opt.ContainerName); status != nil {
+		containerID, restartCount = status.ContainerID, status.RestartCount
+	}
+	// the timestamps are always requested for reopening the stream
+	streamOpt := *opt
+	streamOpt.Timestamps = true
+	var last time.Time
+	for {
+		rc, err := openStream(ctx, k8sClient, &streamOpt)
+		if err != nil {
+			zaplogger.Sugar().Error(err)
+			session.Close(err.Error())
+			return err
+		}
+		ts, err := transmitLines(session, opt, rc, true, last)
+		if cerr := rc.Close(); cerr != nil {
+			zaplogger.Sugar().Error(cerr)
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			session.Close(err.Error())
+			return err
+		}
+		if t, err := time.Parse(time.RFC3339Nano, ts); err == nil {
+			last = t
+		} else if last.IsZero() {
+			last = time.Now()
+		}
+		status, err := waitContainerRestart(ctx, k8sClient, opt.Namespace, opt.PodName, opt.ContainerName, containerID, restartCount)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			zaplogger.Sugar().Infow("LogTransmit stop following", "sessionId", session.Id(), "err", err)
+			break
+		}
+		if status.ContainerID != containerID || status.RestartCount != restartCount {
+			containerID, restartCount = status.ContainerID, status.RestartCount
+			data, err := formatMarkerLine(opt.LogFormat, opt.PodName, opt.ContainerName, LogMarkerRestart,
+				fmt.Sprintf("container %s restarted, restart count %d", opt.ContainerName, status.RestartCount))
+			if err == nil {
+				_, err = session.Write(data)
+			}
+			if err != nil {
+				session.Close(err.Error())
+				return err
+			}
+		}
+		streamOpt.SinceTime = &metav1.Time{Time: last}
+		streamOpt.SinceSeconds = nil
+		streamOpt.TailLines = nil
+		streamOpt.UsePreviousLogs = false
+	}
+	session.Close(ReasonStreamStopped)
+	return nil
+}
+
+// waitContainerRestart re-reads the status of the container every logReconnectInterval once its stream ended, until
+// the container was running as another one than containerID and restartCount, or logRestartSettleTimeout elapsed.
+// The running status would be returned, an error would be returned if the pod was gone or completed.
+func waitContainerRestart(ctx context.Context, k8sClient kubernetes.Interface, namespace, name, container, containerID string, restartCount int32) (*corev1.ContainerStatus, error) {
+	deadline := time.Now().Add(logRestartSettleTimeout)
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(logReconnectInterval):
+		}
+		status, err := waitContainerRunning(ctx, k8sClient, namespace, name, container)
+		if err != nil {
+			return nil, err
+		}
+		if status.ContainerID != containerID || status.RestartCount != restartCount || !time.Now().Before(deadline) {
+			return status, nil
+		}
+	}
+}
+
+func containerStatus(pod *corev1.Pod, container string) *corev1.ContainerStatus {
+	for i := range pod.Status.ContainerStatuses {
+		if pod.Status.ContainerStatuses[i].Name == container {
+			return &pod.Status.ContainerStatuses[i]
+		}
+	}
+	return nil
+}
+
+// runningContainerStatus returns the status of the container if it was running, an error would be returned
+// if the pod was gone or completed
+func runningContainerStatus(pod *corev1.Pod, container string) (*corev1.ContainerStatus, error) {
+	if pod.DeletionTimestamp != nil {
+		return nil, fmt.Errorf("error: the pod:%s was being deleted", pod.Name)
+	}
+	if status := containerStatus(pod, container); status != nil && status.State.Running != nil {
+		return status, nil
+	}
+	if pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+		return nil, fmt.Errorf("error: the pod:%s was completed", pod.Name)
+	}
+	return nil, nil
+}
+
+// waitContainerRunning watches the pod until the container was running
+func waitContainerRunning(ctx context.Context, k8sClient kubernetes.Interface, namespace, name, container string) (*corev1.ContainerStatus, error) {
+	pods := k8sClient.CoreV1().Pods(namespace)
+	for {
+		pod, err := pods.Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		if status, err := runningContainerStatus(pod, container); status != nil || err != nil {
+			return status, err
+		}
+		w, err := pods.Watch(ctx, metav1.ListOptions{
+			FieldSelector:   fields.OneTermEqualSelector("metadata.name", name).String(),
+			ResourceVersion: pod.ResourceVersion,
+		})
+		if err != nil {
+			return nil, err
+		}
+		status, err := waitContainerEvent(ctx, w, container)
+		w.Stop()
+		if status != nil || err != nil {
+			return status, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(podWatchRetryInterval):
+		}
+	}
+}
+
+// waitContainerEvent returns nil status and nil error once the watch was closed
+func waitContainerEvent(ctx context.Context, w watch.Interface, container string) (*corev1.ContainerStatus, error) {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case event, ok := <-w.ResultChan():
+			if !ok {
+				return nil, nil
+			}
+			pod, ok := event.Object.(*corev1.Pod)
+			if !ok {
+				continue
+			}
+			if event.Type == watch.Deleted {
+				return nil, fmt.Errorf("error: the pod:%s was deleted", pod.Name)
+			}
+			if status, err := runningContainerStatus(pod, container); status != nil || err != nil {
+				return status, err
+			}
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package k8s_exec_pod
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/gorilla/websocket"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"testing"
+	"time"
+)
+
+func TestLogTransmitAcrossRestarts(t *testing.T) {
+	pod := newRunningPod("pod-0", nil, "app")
+	pod.Status.ContainerStatuses[0].ContainerID = "containerd://1"
+	s, _ := newFakeServer(t, time.Millisecond*100, pod)
+	defer s.ShutDown()
+
+	ws := dialLog(t, s, getToken(t, s), "followRestarts=true&format=json")
+	defer ws.Close()
+	// every reopening of the same container waits for logRestartSettleTimeout
+	_ = ws.SetReadDeadline(time.Now().Add(time.Second * 20))
+	readLine := func() LogLine {
+		messageType, data, err := ws.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var line LogLine
+		if messageType != websocket.BinaryMessage || json.Unmarshal(data, &line) != nil {
+			t.Fatalf("unexpected message type:%d data:%q", messageType, data)
+		}
+		return line
+	}
+	if line := readLine(); line.Raw != "fake logs" || line.Marker != "" {
+		t.Fatalf("unexpected line %+v", line)
+	}
+
+	// the stream is reopened for the same container without a marker
+	if line := readLine(); line.Raw != "fake logs" || line.Marker != "" {
+		t.Fatalf("unexpected line %+v", line)
+	}
+
+	cluster, err := s.clusters.Get(fakeClusterName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pods := cluster.Client().CoreV1().Pods("default")
+	pod.Status.ContainerStatuses[0].ContainerID = "containerd://2"
+	pod.Status.ContainerStatuses[0].RestartCount = 1
+	if _, err = pods.UpdateStatus(context.Background(), pod, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	// the status is updated after the stream of the old container ended, like the kubelet does after the restart,
+	// the marker must precede the lines of the new container
+	if line := readLine(); line.Marker != LogMarkerRestart || line.Raw != "container app restarted, restart count 1" {
+		t.Fatalf("expected the restart marker before the lines of the new container, got %+v", line)
+	}
+	if line := readLine(); line.Raw != "fake logs" || line.Marker != "" {
+		t.Fatalf("unexpected line %+v", line)
+	}
+
+	// the session is closed once the pod was deleted
+	if err = pods.Delete(context.Background(), pod.Name, metav1.DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	for {
+		if _, _, err = ws.ReadMessage(); err != nil {
+			break
+		}
+	}
+	if !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+		t.Fatalf("expected a normal close frame, got err:%v", err)
+	}
+}
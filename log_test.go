@@ -0,0 +1,82 @@
+package k8s_exec_pod
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestParseSince(t *testing.T) {
+	past := time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+	future := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	cases := []struct {
+		seconds, time string
+		wantSeconds   bool
+		wantTime      bool
+		wantErr       bool
+	}{
+		{"0", "0", false, false, false},
+		{"", "", false, false, false},
+		{"60", "0", true, false, false},
+		{"0", past, false, true, false},
+		{"0", "2021-01-01T08:00:00+08:00", false, true, false},
+		{"60", past, false, false, true},
+		{"-1", "0", false, false, true},
+		{"abc", "0", false, false, true},
+		{"0", "2021-01-01 08:00:00", false, false, true},
+		{"0", future, false, false, true},
+	}
+	for _, c := range cases {
+		seconds, since, err := parseSince(c.seconds, c.time)
+		if (err != nil) != c.wantErr {
+			t.Fatalf("parseSince(%q, %q) err:%v, wantErr:%v", c.seconds, c.time, err, c.wantErr)
+		}
+		if (seconds != nil) != c.wantSeconds || (since != nil) != c.wantTime {
+			t.Fatalf("parseSince(%q, %q) = %v, %v", c.seconds, c.time, seconds, since)
+		}
+	}
+}
+
+func TestInsecureSkipTLSVerifyBackend(t *testing.T) {
+	for _, allowed := range []bool{false, true} {
+		s, _ := newFakeServerWithOptions(t, &ServerOptions{
+			DrainTimeout:                      time.Millisecond * 100,
+			AllowInsecureSkipTLSVerifyBackend: allowed,
+		}, newRunningPod("web-0", nil, "app"))
+		u := fmt.Sprintf("http://%s/namespace/default/pod/web-0/container/app/previous/false/sinceSeconds/0/sinceTime/0", s.Addr())
+
+		res, err := http.Get(u + "?insecureSkipTLSVerifyBackend=true")
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var e HttpResponse
+		if allowed {
+			if string(data) != "fake logs" {
+				t.Fatalf("unexpected logs %q for the allowed insecureSkipTLSVerifyBackend", data)
+			}
+		} else if err = json.Unmarshal(data, &e); err != nil || e.Message != ErrInsecureSkipTLSVerifyBackendNotAllowed {
+			t.Fatalf("expected insecureSkipTLSVerifyBackend was rejected by default, got %q", data)
+		}
+
+		// the explicit false is always accepted
+		e = HttpResponse{}
+		res, err = http.Get(u + "?insecureSkipTLSVerifyBackend=false")
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err = ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil || string(data) != "fake logs" {
+			t.Fatalf("unexpected logs %q err:%v", data, err)
+		}
+		s.ShutDown()
+	}
+}
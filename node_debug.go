@@ -0,0 +1,192 @@
+package k8s_exec_pod
+
+import (
+	"context"
+	"fmt"
+	"github.com/Shanghai-Lunara/pkg/zaplogger"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	utilrand "k8s.io/apimachinery/pkg/util/rand"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"time"
+)
+
+const (
+	// LabelNodeDebug marks the pods created for the node debug sessions
+	LabelNodeDebug = "k8s-exec-pod/node-debug"
+	// LabelInstance is the name of the server instance which created the node debug pod
+	LabelInstance = "k8s-exec-pod/instance"
+	// AnnotationHeartbeat is refreshed by the janitor of the instance whose session was bound to the node debug pod
+	AnnotationHeartbeat = "k8s-exec-pod/heartbeat"
+)
+
+const (
+	nodeDebugContainerName = "debugger"
+	nodeDebugPodPrefix     = "node-debugger-"
+	// nodeDebugHostPath is where the filesystem of the host was mounted inside the node debug pod
+	nodeDebugHostPath = "/host"
+	// nodeDebugGracePeriod protects the pods which were created but not yet bound to a session, or whose heartbeat
+	// was refreshed recently by the instance of the session, from the janitors
+	nodeDebugGracePeriod = time.Minute * 2
+)
+
+// NodeDebugOptions configures the node debug pods
+type NodeDebugOptions struct {
+	// Namespace where the node debug pods would be created, the node debug sessions would be disabled if it was empty
+	Namespace string
+	// Instance is the name of this server instance, it was labeled on the node debug pods for tracing
+	Instance string
+	// MaxLifetime is set to the activeDeadlineSeconds of the node debug pods
+	MaxLifetime time.Duration
+	// JanitorInterval is the interval of deleting the orphan node debug pods and refreshing the heartbeat of
+	// the bound ones, it would be shortened to the half of nodeDebugGracePeriod at most
+	JanitorInterval time.Duration
+}
+
+// CreateNodeDebugPod creates a short-lived privileged pod on the node with hostPID, hostNetwork and the host
+// filesystem mounted at /host, it waits up to timeout for the pod to be running
+func CreateNodeDebugPod(k8sClient kubernetes.Interface, opt *NodeDebugOptions, nodeName, image string, timeout time.Duration) (*corev1.Pod, error) {
+	name := nodeDebugPodPrefix + nodeName
+	if len(name) > 57 {
+		name = name[:57]
+	}
+	name = fmt.Sprintf("%s-%s", name, utilrand.String(5))
+	privileged := true
+	deadline := int64(opt.MaxLifetime.Seconds())
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: opt.Namespace,
+			Labels: map[string]string{
+				LabelNodeDebug: "true",
+				LabelInstance:  opt.Instance,
+			},
+		},
+		Spec: corev1.PodSpec{
+			NodeName:              nodeName,
+			HostPID:               true,
+			HostNetwork:           true,
+			HostIPC:               true,
+			RestartPolicy:         corev1.RestartPolicyNever,
+			ActiveDeadlineSeconds: &deadline,
+			Tolerations:           []corev1.Toleration{{Operator: corev1.TolerationOpExists}},
+			Containers: []corev1.Container{{
+				Name:            nodeDebugContainerName,
+				Image:           image,
+				ImagePullPolicy: corev1.PullIfNotPresent,
+				Stdin:           true,
+				TTY:             true,
+				SecurityContext: &corev1.SecurityContext{Privileged: &privileged},
+				VolumeMounts:    []corev1.VolumeMount{{Name: "host-root", MountPath: nodeDebugHostPath}},
+			}},
+			Volumes: []corev1.Volume{{
+				Name:         "host-root",
+				VolumeSource: corev1.VolumeSource{HostPath: &corev1.HostPathVolumeSource{Path: "/"}},
+			}},
+		},
+	}
+	if deadline <= 0 {
+		pod.Spec.ActiveDeadlineSeconds = nil
+	}
+	ctx := context.Background()
+	pod, err := k8sClient.CoreV1().Pods(opt.Namespace).Create(ctx, pod, metav1.CreateOptions{})
+	if err != nil {
+		return nil, err
+	}
+	zaplogger.Sugar().Infow("CreateNodeDebugPod", "namespace", pod.Namespace, "pod", pod.Name, "node", nodeName, "image", image)
+	err = wait.PollImmediate(debugPollInterval, timeout, func() (bool, error) {
+		pod, err = k8sClient.CoreV1().Pods(opt.Namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		switch pod.Status.Phase {
+		case corev1.PodRunning:
+			return true, nil
+		case corev1.PodSucceeded, corev1.PodFailed:
+			return false, fmt.Errorf("error: the node debug pod:%s was %s", name, pod.Status.Phase)
+		}
+		return false, nil
+	})
+	if err != nil {
+		DeleteNodeDebugPod(k8sClient, opt.Namespace, name)
+		return nil, fmt.Errorf("error: wait for the node debug pod:%s err:%v", name, err)
+	}
+	return pod, nil
+}
+
+// DeleteNodeDebugPod deletes the node debug pod immediately
+func DeleteNodeDebugPod(k8sClient kubernetes.Interface, namespace, name string) {
+	var grace int64
+	if err := k8sClient.CoreV1().Pods(namespace).Delete(context.Background(), name, metav1.DeleteOptions{GracePeriodSeconds: &grace}); err != nil {
+		zaplogger.Sugar().Errorw("DeleteNodeDebugPod", "namespace", namespace, "pod", name, "err", err)
+		return
+	}
+	zaplogger.Sugar().Infow("DeleteNodeDebugPod", "namespace", namespace, "pod", name)
+}
+
+// RunNodeDebugJanitor deletes the orphan node debug pods every opt.JanitorInterval until the ctx was done.
+// The pods which were finished, or whose heartbeat was not refreshed within nodeDebugGracePeriod by any instance
+// (e.g. left by a crash or a restart of the server under another name) would be deleted.
+func RunNodeDebugJanitor(ctx context.Context, k8sClient kubernetes.Interface, sessionHub SessionHub, opt *NodeDebugOptions) {
+	interval := opt.JanitorInterval
+	if interval <= 0 || interval > nodeDebugGracePeriod/2 {
+		interval = nodeDebugGracePeriod / 2
+	}
+	wait.Until(func() {
+		cleanNodeDebugPods(k8sClient, sessionHub, opt)
+	}, interval, ctx.Done())
+}
+
+// cleanNodeDebugPods refreshes the heartbeat of the pods bound to the sessions of this instance, and deletes the
+// other pods once they were finished or their heartbeat was expired, regardless of the instance which created them,
+// so that the pods of the instances which were gone would not survive them
+func cleanNodeDebugPods(k8sClient kubernetes.Interface, sessionHub SessionHub, opt *NodeDebugOptions) {
+	list, err := k8sClient.CoreV1().Pods(opt.Namespace).List(context.Background(), metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("%s=true", LabelNodeDebug),
+	})
+	if err != nil {
+		zaplogger.Sugar().Errorw("cleanNodeDebugPods", "namespace", opt.Namespace, "err", err)
+		return
+	}
+	bound := make(map[string]bool)
+	for _, s := range sessionHub.List() {
+		if s.Option().Namespace == opt.Namespace {
+			bound[s.Option().PodName] = true
+		}
+	}
+	now := time.Now()
+	for _, pod := range list.Items {
+		if pod.DeletionTimestamp != nil {
+			continue
+		}
+		if bound[pod.Name] {
+			refreshNodeDebugHeartbeat(k8sClient, pod.Namespace, pod.Name, now)
+			continue
+		}
+		switch {
+		case pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed:
+		case now.Sub(nodeDebugLastSeen(&pod)) > nodeDebugGracePeriod:
+		default:
+			continue
+		}
+		DeleteNodeDebugPod(k8sClient, pod.Namespace, pod.Name)
+	}
+}
+
+// nodeDebugLastSeen returns the last heartbeat of the pod, or the creation time if it was never refreshed
+func nodeDebugLastSeen(pod *corev1.Pod) time.Time {
+	last := pod.CreationTimestamp.Time
+	if heartbeat, err := time.Parse(time.RFC3339, pod.Annotations[AnnotationHeartbeat]); err == nil && heartbeat.After(last) {
+		last = heartbeat
+	}
+	return last
+}
+
+func refreshNodeDebugHeartbeat(k8sClient kubernetes.Interface, namespace, name string, now time.Time) {
+	patch := fmt.Sprintf(`{"metadata":{"annotations":{%q:%q}}}`, AnnotationHeartbeat, now.UTC().Format(time.RFC3339))
+	if _, err := k8sClient.CoreV1().Pods(namespace).Patch(context.Background(), name, types.MergePatchType, []byte(patch), metav1.PatchOptions{}); err != nil {
+		zaplogger.Sugar().Errorw("refreshNodeDebugHeartbeat", "namespace", namespace, "pod", name, "err", err)
+	}
+}
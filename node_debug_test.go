@@ -0,0 +1,63 @@
+package k8s_exec_pod
+
+import (
+	"context"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
+	"testing"
+	"time"
+)
+
+func newNodeDebugPod(name, instance string, phase corev1.PodPhase, heartbeat time.Time) *corev1.Pod {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "debug",
+			Labels:    map[string]string{LabelNodeDebug: "true", LabelInstance: instance},
+		},
+		Status: corev1.PodStatus{Phase: phase},
+	}
+	if !heartbeat.IsZero() {
+		pod.Annotations = map[string]string{AnnotationHeartbeat: heartbeat.UTC().Format(time.RFC3339)}
+	}
+	return pod
+}
+
+func TestCleanNodeDebugPods(t *testing.T) {
+	now := time.Now()
+	k8sClient := fake.NewSimpleClientset(
+		newNodeDebugPod("bound", "a", corev1.PodRunning, time.Time{}),
+		newNodeDebugPod("orphan", "a", corev1.PodRunning, time.Time{}),
+		newNodeDebugPod("finished", "b", corev1.PodFailed, now),
+		// the pod of a live session of another instance whose heartbeat was refreshed
+		newNodeDebugPod("other", "b", corev1.PodRunning, now.Add(-time.Minute)),
+		// the pod of an instance which was gone, e.g. restarted under another hostname
+		newNodeDebugPod("expired", "c", corev1.PodRunning, now.Add(-nodeDebugGracePeriod*2)),
+	)
+	sessionHub := NewSessionHub(k8sClient, &rest.Config{})
+	if _, err := sessionHub.New(&ExecOptions{Namespace: "debug", PodName: "bound"}); err != nil {
+		t.Fatal(err)
+	}
+	cleanNodeDebugPods(k8sClient, sessionHub, &NodeDebugOptions{Namespace: "debug", Instance: "a"})
+
+	list, err := k8sClient.CoreV1().Pods("debug").List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	left := make(map[string]bool)
+	for _, pod := range list.Items {
+		left[pod.Name] = true
+	}
+	if len(left) != 2 || !left["bound"] || !left["other"] {
+		t.Fatalf("unexpected pods left: %v", left)
+	}
+	pod, err := k8sClient.CoreV1().Pods("debug").Get(context.Background(), "bound", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last := nodeDebugLastSeen(pod); time.Since(last) > time.Minute {
+		t.Fatalf("expected the heartbeat of the bound pod was refreshed, got %v", pod.Annotations)
+	}
+}
@@ -3,19 +3,103 @@ package k8s_exec_pod
 import (
 	"io"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"time"
+)
+
+// ServerOptions passed to InitServer
+type ServerOptions struct {
+	Addr string
+	// DrainTimeout is how long ShutDown waits for the live sessions to end by themselves
+	DrainTimeout time.Duration
+	// Policy restricts the namespaces of the exec, log and discovery routes
+	Policy *Policy
+	// DebugTimeout is how long to wait for an ephemeral debug container to be running
+	DebugTimeout time.Duration
+	// NodeDebug configures the node debug sessions, they would be disabled if it was nil
+	NodeDebug *NodeDebugOptions
+	// MaxUploadBytes limits the body of an upload, no limit if it was not positive
+	MaxUploadBytes int64
+	// MaxDownloadBytes limits the tar stream of a download, no limit if it was not positive
+	MaxDownloadBytes int64
+	// MaxBundlePods limits the pods matched by a support bundle, no limit if it was not positive.
+	// The tar stream of a support bundle is limited by MaxDownloadBytes as well.
+	MaxBundlePods int
+	// LogBufferBytes bounds the queued output of every log session, DefaultLogBufferBytes if it was not positive
+	LogBufferBytes int
+	// CompressionLevel negotiates the permessage-deflate extension of the websockets by the flate level,
+	// from -2 (flate.HuffmanOnly) to 9 (flate.BestCompression). The compression would be disabled if it was 0.
+	CompressionLevel int
+	// OutputFlushInterval batches the output of the exec and port forwarding sessions into fewer frames,
+	// DefaultOutputFlushInterval if it was zero, the batching would be disabled if it was negative
+	OutputFlushInterval time.Duration
+	// WriteTimeout is the write deadline of the websocket messages, DefaultWriteTimeout if it was not positive.
+	// The output of the exec and port forwarding sessions which was not queued within it would be handled by the
+	// SlowConsumerPolicy, SlowConsumerDisconnect if it was empty. SlowConsumerDrop covers the exec and attach sessions
+	// only, the port forwarding sessions are always disconnected.
+	WriteTimeout       time.Duration
+	SlowConsumerPolicy SlowConsumerPolicy
+	// AllowInsecureSkipTLSVerifyBackend allows the log routes to skip verifying the serving certificate of the kubelet
+	// by the `insecureSkipTLSVerifyBackend` query, it was rejected by default
+	AllowInsecureSkipTLSVerifyBackend bool
+}
+
+// SessionKind is what the token of a session was minted for, the session could only be connected by the routes
+// of its kind
+type SessionKind string
+
+const (
+	// SessionKindShell is an exec or attach session, the logs of its container could be streamed by the token as well
+	SessionKindShell SessionKind = "shell"
+	// SessionKindLog streams the aggregated logs or the Events
+	SessionKindLog SessionKind = "log"
+	// SessionKindPortForward tunnels the port of the pod
+	SessionKindPortForward SessionKind = "portforward"
 )
 
 // ExecOptions passed to ExecWithOptions
 type ExecOptions struct {
+	Kind          SessionKind
 	Command       []string
 	Namespace     string
 	PodName       string
 	ContainerName string
+	// Attach uses the pods/attach subresource instead of the pods/exec, the Command would be ignored
+	Attach bool
+	// Port of the pod for the port forwarding sessions
+	Port int32
+
+	// Selector is the label selector of an aggregated log session, the PodName would be ignored if it was set
+	Selector string
+	// LogFormat frames the lines of a log session
+	LogFormat LogFormat
+	// LogBufferBytes bounds the output of a log session which was queued for a slow or paused client,
+	// the frames would be dropped by the LogDropPolicy once the bound was hit
+	LogBufferBytes int
+	LogDropPolicy  LogDropPolicy
+	// OutputFlushInterval, WriteTimeout and SlowConsumerPolicy control the output of the session,
+	// see the ServerOptions
+	OutputFlushInterval time.Duration
+	WriteTimeout        time.Duration
+	SlowConsumerPolicy  SlowConsumerPolicy
+	// FollowRestarts keeps following the log once the container was restarted, the output is framed per line
+	FollowRestarts bool
+	// Events makes a log session stream the Events of the pod, or of the pods of the Selector, instead of the logs
+	Events bool
+	// WithEvents interleaves the Events into a log session as the LogMarkerEvent lines
+	WithEvents bool
+	// WorkloadKind and WorkloadName select the Events of the workload as well as the Events of its pods
+	WorkloadKind WorkloadKind
+	WorkloadName string
 
 	Follow          bool
 	UsePreviousLogs bool
 	SinceSeconds    *int64
 	SinceTime       *metav1.Time
+	Timestamps      bool
+	TailLines       *int64
+	LimitBytes      *int64
+
+	InsecureSkipTLSVerifyBackend bool
 
 	Stdin         io.Reader
 	CaptureStdout bool
@@ -0,0 +1,178 @@
+package k8s_exec_pod
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/websocket"
+	"sync"
+	"time"
+)
+
+// DefaultOutputFlushInterval is how long the output of a session would be coalesced if
+// ExecOptions.OutputFlushInterval was zero
+const DefaultOutputFlushInterval = time.Millisecond * 10
+
+// maxOutputFrameBytes flushes the coalesced output at once once it was large enough
+const maxOutputFrameBytes = 32 << 10
+
+// SlowConsumerPolicy decides what to do with the output once the client did not keep up with it
+// within the write timeout
+type SlowConsumerPolicy string
+
+const (
+	// SlowConsumerDisconnect closes the session
+	SlowConsumerDisconnect SlowConsumerPolicy = "disconnect"
+	// SlowConsumerDrop drops the output, and tells the client how many bytes were dropped by a ControlOutputDropped
+	// message before the next output. It covers the exec and attach sessions only, the port forwarding sessions
+	// are always disconnected since the tunneled stream could not lose any bytes.
+	SlowConsumerDrop SlowConsumerPolicy = "drop"
+)
+
+const (
+	ErrSlowConsumerPolicyNotSupported = "error: the slow consumer policy:%v was not supported"
+	ErrSlowConsumer                   = "error: the client did not receive the output within %v"
+)
+
+func parseSlowConsumerPolicy(policy string) (SlowConsumerPolicy, error) {
+	switch p := SlowConsumerPolicy(policy); p {
+	case "":
+		return SlowConsumerDisconnect, nil
+	case SlowConsumerDisconnect, SlowConsumerDrop:
+		return p, nil
+	default:
+		return "", fmt.Errorf(ErrSlowConsumerPolicyNotSupported, policy)
+	}
+}
+
+// outputBuffer coalesces the output of the session.Write calls into fewer websocket.BinaryMessage frames.
+// The output is flushed once the interval elapsed, or at once by the write once maxOutputFrameBytes were coalesced,
+// which blocks a chatty process, e.g. `yes`, until the frame was queued onto the websocket. The output is flushed
+// by every write if the interval was negative. A frame which could not be queued within the timeout is handled
+// by the SlowConsumerPolicy.
+type outputBuffer struct {
+	mu       sync.Mutex
+	buf      []byte
+	timer    *time.Timer
+	interval time.Duration
+	timeout  time.Duration
+	policy   SlowConsumerPolicy
+	dropped  int64
+	err      error
+
+	// sendMu keeps the order of the flushes
+	sendMu sync.Mutex
+	send   func(messageType int, data []byte, timeout time.Duration) error
+	// onError is called once a flush by the timer failed, e.g. closing the session
+	onError func(err error)
+}
+
+func newOutputBuffer(interval, timeout time.Duration, policy SlowConsumerPolicy,
+	send func(messageType int, data []byte, timeout time.Duration) error, onError func(err error)) *outputBuffer {
+	if interval == 0 {
+		interval = DefaultOutputFlushInterval
+	}
+	if timeout <= 0 {
+		timeout = DefaultWriteTimeout
+	}
+	if policy == "" {
+		policy = SlowConsumerDisconnect
+	}
+	return &outputBuffer{
+		interval: interval,
+		timeout:  timeout,
+		policy:   policy,
+		send:     send,
+		onError:  onError,
+	}
+}
+
+// write appends the output to the buffer. It fails once a flush failed.
+func (b *outputBuffer) write(p []byte) error {
+	b.mu.Lock()
+	if b.err != nil {
+		b.mu.Unlock()
+		return b.err
+	}
+	b.buf = append(b.buf, p...)
+	if b.interval < 0 || len(b.buf) >= maxOutputFrameBytes {
+		b.mu.Unlock()
+		return b.flush(b.timeout)
+	}
+	if b.timer == nil {
+		b.timer = time.AfterFunc(b.interval, func() {
+			if err := b.flush(b.timeout); err != nil && b.onError != nil {
+				b.onError(err)
+			}
+		})
+	}
+	b.mu.Unlock()
+	return nil
+}
+
+// flush sends the coalesced output at once, the frames would be queued within the timeout
+func (b *outputBuffer) flush(timeout time.Duration) error {
+	b.sendMu.Lock()
+	defer b.sendMu.Unlock()
+	b.mu.Lock()
+	data := b.buf
+	b.buf = nil
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	err, dropped := b.err, b.dropped
+	b.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if dropped > 0 {
+		notice, err := json.Marshal(&ControlMsg{
+			MsgType: ControlOutputDropped,
+			Message: fmt.Sprintf("%d bytes of the output were dropped", dropped),
+			Count:   dropped,
+		})
+		if err != nil {
+			return b.fail(err)
+		}
+		// the output is dropped without waiting for the client once again until the notice was sent
+		if err = b.send(websocket.TextMessage, notice, 0); err != nil {
+			if err != errSendTimeout {
+				return b.fail(err)
+			}
+			b.drop(len(data))
+			return nil
+		}
+		b.mu.Lock()
+		b.dropped -= dropped
+		b.mu.Unlock()
+	}
+	if len(data) == 0 {
+		return nil
+	}
+	if err = b.send(websocket.BinaryMessage, data, timeout); err != nil {
+		if err != errSendTimeout {
+			return b.fail(err)
+		}
+		if b.policy != SlowConsumerDrop {
+			return b.fail(fmt.Errorf(ErrSlowConsumer, b.timeout))
+		}
+		b.drop(len(data))
+	}
+	return nil
+}
+
+func (b *outputBuffer) drop(n int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.dropped += int64(n)
+}
+
+// fail fails the following writes and flushes by the err
+func (b *outputBuffer) fail(err error) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.err == nil {
+		b.err = err
+	}
+	return b.err
+}
@@ -0,0 +1,122 @@
+package k8s_exec_pod
+
+import (
+	"compress/flate"
+	"context"
+	"fmt"
+	"github.com/gorilla/websocket"
+	"strings"
+	"testing"
+	"time"
+)
+
+// slowFrames records the frames, the sends time out while slow was set
+type slowFrames struct {
+	recordedFrames
+	slow bool
+}
+
+func (r *slowFrames) send(messageType int, data []byte, timeout time.Duration) error {
+	r.mu.Lock()
+	slow := r.slow
+	r.mu.Unlock()
+	if slow {
+		return errSendTimeout
+	}
+	return r.recordedFrames.send(messageType, data)
+}
+
+func (r *slowFrames) setSlow(slow bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.slow = slow
+}
+
+func TestOutputBuffer(t *testing.T) {
+	r := &slowFrames{}
+	b := newOutputBuffer(time.Millisecond*50, time.Second, "", r.send, nil)
+	for _, p := range []string{"a", "b", "c"} {
+		if err := b.write([]byte(p)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(time.Millisecond * 200)
+	if err := b.write([]byte("d")); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.flush(time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if res := r.String(); res != "[abc d]" {
+		t.Fatalf("expected the coalesced frames [abc d], got %s", res)
+	}
+
+	// the large output is flushed at once without waiting for the interval
+	r = &slowFrames{}
+	b = newOutputBuffer(time.Hour, time.Second, "", r.send, nil)
+	if err := b.write(make([]byte, maxOutputFrameBytes)); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(r.frames); n != 1 {
+		t.Fatalf("expected the full frame to be flushed, got %d frames", n)
+	}
+
+	r = &slowFrames{}
+	b = newOutputBuffer(-1, time.Second, "", r.send, nil)
+	for _, p := range []string{"a", "b"} {
+		if err := b.write([]byte(p)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if res := r.String(); res != "[a b]" {
+		t.Fatalf("expected the frames to be sent at once, got %s", res)
+	}
+}
+
+func TestOutputBufferSlowConsumer(t *testing.T) {
+	r := &slowFrames{slow: true}
+	b := newOutputBuffer(-1, time.Second, SlowConsumerDisconnect, r.send, nil)
+	if err := b.write([]byte("a")); err == nil {
+		t.Fatal("expected the slow consumer error")
+	}
+	if err := b.write([]byte("b")); err == nil {
+		t.Fatal("expected the write to fail after the slow consumer error")
+	}
+
+	r = &slowFrames{slow: true}
+	b = newOutputBuffer(-1, time.Second, SlowConsumerDrop, r.send, nil)
+	for _, p := range []string{"ab", "cde"} {
+		if err := b.write([]byte(p)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r.setSlow(false)
+	if err := b.write([]byte("f")); err != nil {
+		t.Fatal(err)
+	}
+	if res := r.String(); res != "[output_dropped:5 f]" {
+		t.Fatalf("expected the notice of the dropped output before the next output, got %s", res)
+	}
+}
+
+func TestPerMessageCompression(t *testing.T) {
+	if _, _, err := InitServer(context.Background(), &ServerOptions{Addr: "127.0.0.1:0", CompressionLevel: 10}, nil); err == nil {
+		t.Fatal("expected an error for the invalid compression level")
+	}
+	s, _ := newFakeServerWithOptions(t, &ServerOptions{DrainTimeout: time.Millisecond * 100, CompressionLevel: flate.BestSpeed})
+	defer s.ShutDown()
+	u := fmt.Sprintf("ws://%s/cluster/%s/log/sinceSeconds/0/sinceTime/0/token/%s", s.Addr(), fakeClusterName, getToken(t, s))
+	dialer := websocket.Dialer{EnableCompression: true}
+	ws, res, err := dialer.Dial(u, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	if ext := res.Header.Get("Sec-Websocket-Extensions"); !strings.Contains(ext, "permessage-deflate") {
+		t.Fatalf("expected permessage-deflate to be negotiated, got %q", ext)
+	}
+	_ = ws.SetReadDeadline(time.Now().Add(time.Second * 5))
+	if _, data, err := ws.ReadMessage(); err != nil || string(data) != "fake logs" {
+		t.Fatalf("unexpected message %q err:%v", data, err)
+	}
+}
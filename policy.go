@@ -0,0 +1,98 @@
+package k8s_exec_pod
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+const (
+	ErrNamespaceNotAllowed  = "error: the namespace:%v was not allowed"
+	ErrDebugImageNotAllowed = "error: the debug image:%v was not allowed"
+	ErrPathNotAllowed       = "error: the path:%v was not allowed"
+)
+
+// Policy restricts what the clients are allowed to access through the server, a nil Policy allows every namespace
+type Policy struct {
+	// AllowedNamespaces are the only namespaces allowed to be accessed if it was not empty
+	AllowedNamespaces []string
+	// DeniedNamespaces are never allowed to be accessed
+	DeniedNamespaces []string
+	// DebugImages are the images allowed for the ephemeral debug containers, the first one is the default,
+	// the debug containers would be disabled if it was empty
+	DebugImages []string
+	// UploadPaths are the directories (and their sub directories) the files are allowed to be uploaded into,
+	// the uploads would be disabled if it was empty
+	UploadPaths []string
+	// DownloadPaths are the directories (and their sub directories) the files are allowed to be downloaded from,
+	// the downloads would be disabled if it was empty
+	DownloadPaths []string
+}
+
+// CheckNamespace returns an error if the namespace was not allowed
+func (p *Policy) CheckNamespace(namespace string) error {
+	if p == nil {
+		return nil
+	}
+	for _, v := range p.DeniedNamespaces {
+		if v == namespace {
+			return fmt.Errorf(ErrNamespaceNotAllowed, namespace)
+		}
+	}
+	if len(p.AllowedNamespaces) == 0 {
+		return nil
+	}
+	for _, v := range p.AllowedNamespaces {
+		if v == namespace {
+			return nil
+		}
+	}
+	return fmt.Errorf(ErrNamespaceNotAllowed, namespace)
+}
+
+// DebugImage returns the image if it was allowed, the default one would be returned if the image was empty
+func (p *Policy) DebugImage(image string) (string, error) {
+	if p == nil || len(p.DebugImages) == 0 {
+		return "", fmt.Errorf(ErrDebugImageNotAllowed, image)
+	}
+	if image == "" {
+		return p.DebugImages[0], nil
+	}
+	for _, v := range p.DebugImages {
+		if v == image {
+			return image, nil
+		}
+	}
+	return "", fmt.Errorf(ErrDebugImageNotAllowed, image)
+}
+
+// UploadPath returns the cleaned destination directory if it was allowed
+func (p *Policy) UploadPath(dir string) (string, error) {
+	if p == nil {
+		return "", fmt.Errorf(ErrPathNotAllowed, dir)
+	}
+	return checkPath(p.UploadPaths, dir)
+}
+
+// DownloadPath returns the cleaned path if it was allowed to be downloaded
+func (p *Policy) DownloadPath(file string) (string, error) {
+	if p == nil {
+		return "", fmt.Errorf(ErrPathNotAllowed, file)
+	}
+	return checkPath(p.DownloadPaths, file)
+}
+
+// checkPath returns the cleaned absolute path if it was inside one of the allowed directories
+func checkPath(allowed []string, p string) (string, error) {
+	if !path.IsAbs(p) {
+		return "", fmt.Errorf(ErrPathNotAllowed, p)
+	}
+	p = path.Clean(p)
+	for _, v := range allowed {
+		v = path.Clean(v)
+		if p == v || v == "/" || strings.HasPrefix(p, v+"/") {
+			return p, nil
+		}
+	}
+	return "", fmt.Errorf(ErrPathNotAllowed, p)
+}
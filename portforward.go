@@ -0,0 +1,137 @@
+package k8s_exec_pod
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/Shanghai-Lunara/pkg/zaplogger"
+	"github.com/gorilla/websocket"
+	"io"
+	"io/ioutil"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/httpstream"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/portforward"
+	"k8s.io/client-go/transport/spdy"
+	"net/http"
+	"strconv"
+)
+
+// PortForward is called from Session as a goroutine
+// Tunnels the data of the websocket.BinaryMessage between the websocket and the port of the pod through the
+// pods/portforward subresource, the websocket.TextMessage would be handled as TermMsg (e.g. TermPing)
+func PortForward(k8sClient kubernetes.Interface, cfg *rest.Config, session Session, p Proxy) error {
+	opt := session.Option()
+	zaplogger.Sugar().Infof("portForward Namespace:%s PodName:%s Port:%d", opt.Namespace, opt.PodName, opt.Port)
+	req := k8sClient.CoreV1().RESTClient().Post().
+		Resource("pods").
+		Namespace(opt.Namespace).
+		Name(opt.PodName).
+		SubResource("portforward")
+	transport, upgrader, err := spdy.RoundTripperFor(cfg)
+	if err != nil {
+		zaplogger.Sugar().Error(err)
+		return err
+	}
+	dialer := spdy.NewDialer(upgrader, &http.Client{Transport: transport}, "POST", req.URL())
+	conn, _, err := dialer.Dial(portforward.PortForwardProtocolV1Name)
+	if err != nil {
+		zaplogger.Sugar().Error(err)
+		return err
+	}
+	defer func() {
+		if err := conn.Close(); err != nil {
+			zaplogger.Sugar().Error(err)
+		}
+	}()
+
+	headers := http.Header{}
+	headers.Set(corev1.StreamType, corev1.StreamTypeError)
+	headers.Set(corev1.PortHeader, strconv.Itoa(int(opt.Port)))
+	headers.Set(corev1.PortForwardRequestIDHeader, "0")
+	errorStream, err := conn.CreateStream(headers)
+	if err != nil {
+		zaplogger.Sugar().Error(err)
+		return err
+	}
+	// we're not writing to this stream
+	_ = errorStream.Close()
+	headers.Set(corev1.StreamType, corev1.StreamTypeData)
+	dataStream, err := conn.CreateStream(headers)
+	if err != nil {
+		zaplogger.Sugar().Error(err)
+		return err
+	}
+
+	errChan := make(chan error, 3)
+	go func() {
+		message, err := ioutil.ReadAll(errorStream)
+		switch {
+		case err != nil:
+			errChan <- fmt.Errorf("error reading from error stream for port %d: %v", opt.Port, err)
+		case len(message) > 0:
+			errChan <- fmt.Errorf("an error occurred forwarding port %d: %s", opt.Port, string(message))
+		}
+	}()
+	go func() {
+		// the pod -> the websocket
+		if _, err := io.Copy(session, dataStream); err != nil {
+			errChan <- err
+			return
+		}
+		errChan <- nil
+	}()
+	go func() {
+		// the websocket -> the pod
+		errChan <- forwardRecv(p, dataStream)
+	}()
+	select {
+	case err = <-errChan:
+	case <-session.Ctx().Done():
+	}
+	resetStream(conn, dataStream)
+	if isNormalClose(err) {
+		// the client closed the tunnel, or the pod closed the port
+		zaplogger.Sugar().Infow("portForward stopped", "sessionId", session.Id(), "reason", err)
+		return nil
+	}
+	return err
+}
+
+// forwardRecv writes the data of the websocket.BinaryMessage into w until the proxy was closed
+func forwardRecv(p Proxy, w io.WriteCloser) error {
+	defer func() {
+		if err := w.Close(); err != nil {
+			zaplogger.Sugar().Error(err)
+		}
+	}()
+	for {
+		msg, err := p.Recv()
+		if err != nil {
+			return err
+		}
+		if msg.messageType == websocket.BinaryMessage {
+			if _, err = w.Write(msg.data); err != nil {
+				return err
+			}
+			continue
+		}
+		var termMsg TermMsg
+		if err = json.Unmarshal(msg.data, &termMsg); err != nil {
+			return err
+		}
+		switch termMsg.MsgType {
+		case TermPing:
+			p.HandlePing()
+		default:
+			return fmt.Errorf("unknown message type '%s'", termMsg.MsgType)
+		}
+	}
+}
+
+func resetStream(conn httpstream.Connection, stream httpstream.Stream) {
+	if err := stream.Reset(); err != nil {
+		zaplogger.Sugar().Error(err)
+	}
+	conn.RemoveStreams(stream)
+}
@@ -1,8 +1,53 @@
 package k8s_exec_pod
 
 const (
-	RouterPodShellToken  = "/namespace/:namespace/pod/:pod/shell/:container/:command"
-	RouterSSH            = "/ssh/:token"
+	RouterPodShellToken = "/namespace/:namespace/pod/:pod/shell/:container/:command"
+	// RouterPodAttachToken creates a token attaching to the main process of the container through the same RouterSSH
+	RouterPodAttachToken = "/namespace/:namespace/pod/:pod/attach/:container"
+	// RouterNodeShellToken creates a privileged pod on the node for the shell, it accepts the `debugImage` query
+	RouterNodeShellToken = "/node/:node/shell/:command"
+	// RouterWorkloadShellToken accepts the `container`, `strategy` and `ordinal` queries
+	RouterWorkloadShellToken = "/namespace/:namespace/workload/:kind/:name/shell/:command"
+	RouterSSH                = "/ssh/:token"
+	// RouterPodPortForwardToken creates a token forwarding the port of the pod through RouterPortForward
+	RouterPodPortForwardToken = "/namespace/:namespace/pod/:pod/portforward/:port"
+	RouterPortForward         = "/portforward/:token"
+	// RouterPodUpload accepts the `path`, `filename` and `session` queries
+	RouterPodUpload = "/namespace/:namespace/pod/:pod/container/:container/upload"
+	// RouterPodFileDownload accepts the `path` and `format` queries
+	RouterPodFileDownload = "/namespace/:namespace/pod/:pod/container/:container/download"
+	// RouterPodFileList, RouterPodFileStat and RouterPodFileRead accept the `path` query,
+	// RouterPodFileRead accepts the `offset` and `length` queries as well
+	RouterPodFileList = "/namespace/:namespace/pod/:pod/container/:container/files"
+	RouterPodFileStat = "/namespace/:namespace/pod/:pod/container/:container/file/stat"
+	RouterPodFileRead = "/namespace/:namespace/pod/:pod/container/:container/file/read"
+	// RouterLogAggregateToken creates a token streaming the logs of all the pods matching the `selector` query,
+	// or the pods of the workload of the `kind` and `name` queries, through RouterPodLogStream.
+	// It accepts the `container` and `format` queries as well
+	RouterLogAggregateToken = "/namespace/:namespace/logs"
+	// RouterEventsToken creates a token streaming the Events of the `pod` query, the pods matching the `selector`
+	// query, or the workload of the `kind` and `name` queries through RouterPodLogStream.
+	// It accepts the `format` query, `json` by default
+	RouterEventsToken    = "/namespace/:namespace/events"
 	RouterPodLogStream   = "/log/sinceSeconds/:SinceSeconds/sinceTime/:SinceTime/token/:token"
 	RouterPodLogDownload = "/namespace/:namespace/pod/:pod/container/:container/previous/:previous/sinceSeconds/:SinceSeconds/sinceTime/:SinceTime"
+	// RouterPodLogArchive downloads the logs of the containers of the pod in a tar,
+	// it accepts the `container`, `previous` and `compress` queries
+	RouterPodLogArchive = "/namespace/:namespace/pod/:pod/logs/archive"
+	// RouterSupportBundle downloads the logs, manifests, events and node assignments of the pods matching
+	// the `selector` query, or the pods of the workload of the `kind` and `name` queries, in a tar.
+	// It accepts the `previous` and `compress` queries and the log options as well
+	RouterSupportBundle = "/namespace/:namespace/bundle"
+
+	RouterNamespaceList = "/namespaces"
+	RouterPodList       = "/namespace/:namespace/pods"
+	RouterContainerList = "/namespace/:namespace/pod/:pod/containers"
+)
+
+// RouterCluster prefixes the routes which were bound to a specific cluster,
+// the routes without the prefix would be served by the default cluster
+const RouterCluster = "/cluster/:cluster"
+
+const (
+	RouterClusterList = "/clusters"
 )
@@ -1,15 +1,24 @@
 package k8s_exec_pod
 
 import (
+	"archive/tar"
+	"compress/flate"
 	"context"
 	"fmt"
 	"github.com/Shanghai-Lunara/pkg/zaplogger"
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 	"io"
-	"k8s.io/client-go/kubernetes"
+	"io/ioutil"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"net"
 	"net/http"
+	"path"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -18,123 +27,1107 @@ const (
 	CodeError
 )
 
+const (
+	ErrServerDraining                 = "error: the server is shutting down"
+	ErrSessionKindNotAllowed          = "error: the session:%v of kind:%v was not allowed by the route"
+	ErrInvalidCompressionLevel        = "error: invalid compression level:%v, it must be from -2 to 9"
+	ErrLogFormatJSONWithoutTimestamps = "error: the json log format always carries the timestamps, timestamps=false was not allowed"
+	// ErrInsecureSkipTLSVerifyBackendNotAllowed is returned by the log routes unless
+	// ServerOptions.AllowInsecureSkipTLSVerifyBackend was set
+	ErrInsecureSkipTLSVerifyBackendNotAllowed = "error: the insecureSkipTLSVerifyBackend was not allowed by the server"
+)
+
+// drainCheckInterval is the interval of checking whether all the sessions were drained
+const drainCheckInterval = time.Millisecond * 200
+
+// forceCloseTimeout bounds the wait of the sessions which were closed by force at the end of the drain
+const forceCloseTimeout = time.Second
+
+// shutdownNotifyTimeout bounds the wait of notifying the sessions of the restart, the sessions which were stalled
+// would still be notified in the background during the drain
+const shutdownNotifyTimeout = time.Second
+
 type Server struct {
-	server     *http.Server
-	ctx        context.Context
-	k8sClient  kubernetes.Interface
-	sessionHub SessionHub
+	server   *http.Server
+	listener net.Listener
+	ctx      context.Context
+	cancel   context.CancelFunc
+	option   *ServerOptions
+	clusters ClusterHub
+	draining int32
+
+	serveErr  error
+	serveDone chan struct{}
 }
 
-func InitServer(ctx context.Context, addr, kubeconfig, masterUrl string) *Server {
-	cfg, k8sClient := NewResource(masterUrl, kubeconfig)
+// InitServer starts serving on ServerOptions.Addr, the returned context would be done once the server stopped,
+// either after ShutDown returned or because the listener failed unexpectedly.
+func InitServer(ctx context.Context, option *ServerOptions, clusters ClusterHub) (*Server, context.Context, error) {
+	if option.CompressionLevel < flate.HuffmanOnly || option.CompressionLevel > flate.BestCompression {
+		return nil, nil, fmt.Errorf(ErrInvalidCompressionLevel, option.CompressionLevel)
+	}
+	if _, err := parseSlowConsumerPolicy(string(option.SlowConsumerPolicy)); err != nil {
+		return nil, nil, err
+	}
+	ln, err := net.Listen("tcp", option.Addr)
+	if err != nil {
+		zaplogger.Sugar().Error(err)
+		return nil, nil, err
+	}
+	subCtx, cancel := context.WithCancel(ctx)
 	h := &Server{
-		k8sClient:  k8sClient,
-		sessionHub: NewSessionHub(k8sClient, cfg),
+		listener:  ln,
+		ctx:       subCtx,
+		cancel:    cancel,
+		option:    option,
+		clusters:  clusters,
+		serveDone: make(chan struct{}),
 	}
 	router := gin.New()
 	router.Use(cors.Default())
-	router.GET(RouterPodShellToken, h.PodToken)
-	router.GET(RouterSSH, h.SSH)
-	router.GET(RouterPodLogStream, h.LogStream)
-	router.GET(RouterPodLogDownload, h.LogDownload)
+	router.GET(RouterClusterList, h.ClusterList)
+	for _, group := range []*gin.RouterGroup{&router.RouterGroup, router.Group(RouterCluster)} {
+		group.GET(RouterPodShellToken, h.PodToken)
+		group.GET(RouterPodAttachToken, h.PodAttachToken)
+		group.GET(RouterWorkloadShellToken, h.WorkloadToken)
+		group.GET(RouterNodeShellToken, h.NodeToken)
+		group.GET(RouterPodPortForwardToken, h.PortForwardToken)
+		group.GET(RouterPortForward, h.PortForward)
+		group.POST(RouterPodUpload, h.Upload)
+		group.GET(RouterPodFileDownload, h.FileDownload)
+		group.GET(RouterPodFileList, h.FileList)
+		group.GET(RouterPodFileStat, h.FileStat)
+		group.GET(RouterPodFileRead, h.FileRead)
+		group.GET(RouterSSH, h.SSH)
+		group.GET(RouterLogAggregateToken, h.LogAggregateToken)
+		group.GET(RouterEventsToken, h.EventsToken)
+		group.GET(RouterPodLogStream, h.LogStream)
+		group.GET(RouterPodLogDownload, h.LogDownload)
+		group.GET(RouterPodLogArchive, h.LogArchive)
+		group.GET(RouterSupportBundle, h.SupportBundle)
+		group.GET(RouterNamespaceList, h.NamespaceList)
+		group.GET(RouterPodList, h.PodList)
+		group.GET(RouterContainerList, h.ContainerList)
+	}
+	if option.NodeDebug != nil && option.NodeDebug.Namespace != "" {
+		for _, cluster := range clusters.List() {
+			go RunNodeDebugJanitor(subCtx, cluster.Client(), cluster.SessionHub(), option.NodeDebug)
+		}
+	}
 	h.server = &http.Server{
-		Addr:    addr,
+		Addr:    option.Addr,
 		Handler: router,
 	}
 	go func() {
-		if err := h.server.ListenAndServe(); err != nil {
+		defer close(h.serveDone)
+		if err := h.server.Serve(ln); err != nil {
 			if err == http.ErrServerClosed {
 				zaplogger.Sugar().Info("Server closed under request")
 			} else {
 				zaplogger.Sugar().Info("Server closed unexpected err:", err)
+				h.serveErr = err
+				cancel()
 			}
 		}
 	}()
-	return h
+	return h, subCtx, nil
+}
+
+// Addr returns the address the server was listening on
+func (s *Server) Addr() string {
+	return s.listener.Addr().String()
 }
 
-func (s *Server) ShutDown() {
+// ShutDown stops issuing new tokens and notifies every live session that the server is restarting,
+// then waits up to ServerOptions.DrainTimeout for the sessions to end before closing the remaining ones.
+// It returns once all the sessions and the listener were drained, the error of the listener would be returned if it
+// was stopped unexpectedly.
+func (s *Server) ShutDown() error {
+	defer s.cancel()
+	atomic.StoreInt32(&s.draining, 1)
+	s.eachSession(shutdownNotifyTimeout, func(cluster *Cluster, session Session) {
+		if err := session.Control(&ControlMsg{
+			MsgType: ControlServerRestarting,
+			Message: fmt.Sprintf("server restarting, the session would be closed in %s", s.option.DrainTimeout),
+		}); err != nil {
+			zaplogger.Sugar().Infow("ShutDown notify session failed", "cluster", cluster.Name(), "sessionId", session.Id(), "err", err)
+		}
+	})
+	if !s.drain(s.option.DrainTimeout) {
+		s.eachSession(forceCloseTimeout, func(cluster *Cluster, session Session) {
+			session.Close(ReasonServerShutdown)
+		})
+		if !s.drain(forceCloseTimeout) {
+			zaplogger.Sugar().Info("ShutDown some sessions were not released after closed")
+		}
+	}
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 	if err := s.server.Shutdown(ctx); err != nil {
 		zaplogger.Sugar().Errorf("http.Server shutdown err:%v", err)
+		return err
+	}
+	<-s.serveDone
+	return s.serveErr
+}
+
+// cluster returns the Cluster of the `cluster` path segment, or the default one
+func (s *Server) cluster(c *gin.Context) (*Cluster, error) {
+	cluster, err := s.clusters.Get(c.Param("cluster"))
+	if err != nil {
+		zaplogger.Sugar().Error(err)
+		return nil, err
 	}
+	return cluster, nil
+}
+
+// session returns the session of the token if it was minted for one of the kinds of the route
+func (s *Server) session(cluster *Cluster, token string, kinds ...SessionKind) (Session, error) {
+	session, err := cluster.SessionHub().Get(token)
+	if err != nil {
+		zaplogger.Sugar().Error(err)
+		return nil, err
+	}
+	for _, kind := range kinds {
+		if session.Option().Kind == kind {
+			return session, nil
+		}
+	}
+	err = fmt.Errorf(ErrSessionKindNotAllowed, token, session.Option().Kind)
+	zaplogger.Sugar().Error(err)
+	return nil, err
+}
+
+func (s *Server) ClusterList(c *gin.Context) {
+	c.JSON(http.StatusOK, HttpResponse{
+		Code: CodeSuccess,
+		Data: s.clusters.Status(c.Request.Context()),
+	})
+}
+
+// eachSession calls fn for every live session concurrently, and waits for the calls up to the timeout,
+// so that a stalled session would not hold the others. It reports whether all the calls returned in time.
+func (s *Server) eachSession(timeout time.Duration, fn func(cluster *Cluster, session Session)) bool {
+	var wg sync.WaitGroup
+	for _, cluster := range s.clusters.List() {
+		for _, session := range cluster.SessionHub().List() {
+			wg.Add(1)
+			go func(cluster *Cluster, session Session) {
+				defer wg.Done()
+				fn(cluster, session)
+			}(cluster, session)
+		}
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+	select {
+	case <-done:
+		return true
+	case <-t.C:
+		zaplogger.Sugar().Infow("ShutDown some sessions did not respond in time", "timeout", timeout)
+		return false
+	}
+}
+
+// drain waits until no session was left or the timeout elapsed, it reports whether all the sessions were drained
+func (s *Server) drain(timeout time.Duration) bool {
+	tick := time.NewTicker(drainCheckInterval)
+	defer tick.Stop()
+	deadline := time.After(timeout)
+	for {
+		count := 0
+		for _, cluster := range s.clusters.List() {
+			count += len(cluster.SessionHub().List())
+		}
+		if count == 0 {
+			return true
+		}
+		select {
+		case <-tick.C:
+		case <-deadline:
+			zaplogger.Sugar().Infow("ShutDown drain timeout", "sessions", count)
+			return false
+		}
+	}
+}
+
+func (s *Server) isDraining() bool {
+	return atomic.LoadInt32(&s.draining) == 1
+}
+
+// checkNamespace checks the `namespace` path segment against the Policy
+func (s *Server) checkNamespace(c *gin.Context) error {
+	if err := s.option.Policy.CheckNamespace(c.Param("namespace")); err != nil {
+		zaplogger.Sugar().Error(err)
+		return err
+	}
+	return nil
+}
+
+// jsonError responds the err with CodeError
+func jsonError(c *gin.Context, err error) {
+	c.JSON(http.StatusOK, HttpResponse{
+		Code:    CodeError,
+		Message: err.Error(),
+	})
+}
+
+// abortResponse drops the connection of a response whose headers were already sent, so that the client would see
+// the failure of the transfer instead of a truncated body
+func abortResponse(c *gin.Context) {
+	c.Abort()
+	panic(http.ErrAbortHandler)
+}
+
+func (s *Server) NamespaceList(c *gin.Context) {
+	cluster, err := s.cluster(c)
+	if err != nil {
+		jsonError(c, err)
+		return
+	}
+	namespaces, err := ListNamespaces(cluster.Client(), s.option.Policy)
+	if err != nil {
+		zaplogger.Sugar().Error(err)
+		jsonError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, HttpResponse{Code: CodeSuccess, Data: namespaces})
+}
+
+func (s *Server) PodList(c *gin.Context) {
+	cluster, err := s.cluster(c)
+	if err != nil {
+		jsonError(c, err)
+		return
+	}
+	if err = s.checkNamespace(c); err != nil {
+		jsonError(c, err)
+		return
+	}
+	pods, err := ListPods(cluster.Client(), c.Param("namespace"), c.Query("labelSelector"), c.Query("phase"))
+	if err != nil {
+		zaplogger.Sugar().Error(err)
+		jsonError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, HttpResponse{Code: CodeSuccess, Data: pods})
+}
+
+func (s *Server) ContainerList(c *gin.Context) {
+	cluster, err := s.cluster(c)
+	if err != nil {
+		jsonError(c, err)
+		return
+	}
+	if err = s.checkNamespace(c); err != nil {
+		jsonError(c, err)
+		return
+	}
+	containers, err := ListContainers(cluster.Client(), c.Param("namespace"), c.Param("pod"))
+	if err != nil {
+		zaplogger.Sugar().Error(err)
+		jsonError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, HttpResponse{Code: CodeSuccess, Data: containers})
 }
 
 func (s *Server) PodToken(c *gin.Context) {
+	var res HttpResponse
+	if s.isDraining() {
+		res.Code = CodeError
+		res.Message = ErrServerDraining
+		c.JSON(http.StatusServiceUnavailable, res)
+		return
+	}
+	cluster, err := s.cluster(c)
+	if err != nil {
+		jsonError(c, err)
+		return
+	}
+	if err = s.checkNamespace(c); err != nil {
+		jsonError(c, err)
+		return
+	}
 	option := &ExecOptions{
+		Kind:          SessionKindShell,
 		Namespace:     c.Param("namespace"),
 		PodName:       c.Param("pod"),
 		ContainerName: c.Param("container"),
 		Follow:        true,
 		Command:       []string{c.Param("command")},
 	}
-	var res HttpResponse
-	session, err := s.sessionHub.New(option)
+	if err = s.prepareDebug(c, cluster, option); err != nil {
+		jsonError(c, err)
+		return
+	}
+	session, err := cluster.SessionHub().New(option)
 	if err != nil {
 		res.Code = CodeError
 		res.Message = fmt.Sprintf("Failed to init session err:%s", err.Error())
 	} else {
 		res.Code = CodeSuccess
 		res.Token = session.Id()
+		res.Pod = option.PodName
+		res.Container = option.ContainerName
 	}
-	zaplogger.Sugar().Infof("Namespace:%s PodName:%s ContainerName:%s Command:%v", option.Namespace, option.PodName, option.ContainerName, option.Command)
+	zaplogger.Sugar().Infof("Cluster:%s Namespace:%s PodName:%s ContainerName:%s Command:%v", cluster.Name(), option.Namespace, option.PodName, option.ContainerName, option.Command)
 	c.JSON(http.StatusOK, res)
 }
 
+func (s *Server) PodAttachToken(c *gin.Context) {
+	if s.isDraining() {
+		c.JSON(http.StatusServiceUnavailable, HttpResponse{Code: CodeError, Message: ErrServerDraining})
+		return
+	}
+	cluster, err := s.cluster(c)
+	if err != nil {
+		jsonError(c, err)
+		return
+	}
+	if err = s.checkNamespace(c); err != nil {
+		jsonError(c, err)
+		return
+	}
+	option := &ExecOptions{
+		Kind:          SessionKindShell,
+		Namespace:     c.Param("namespace"),
+		PodName:       c.Param("pod"),
+		ContainerName: c.Param("container"),
+		Attach:        true,
+		Follow:        true,
+	}
+	session, err := cluster.SessionHub().New(option)
+	if err != nil {
+		jsonError(c, fmt.Errorf("Failed to init session err:%s", err.Error()))
+		return
+	}
+	zaplogger.Sugar().Infof("Cluster:%s Attach Namespace:%s PodName:%s ContainerName:%s", cluster.Name(), option.Namespace, option.PodName, option.ContainerName)
+	c.JSON(http.StatusOK, HttpResponse{
+		Code:      CodeSuccess,
+		Token:     session.Id(),
+		Pod:       option.PodName,
+		Container: option.ContainerName,
+	})
+}
+
+// prepareDebug injects an ephemeral debug container targeting option.ContainerName if the `debug` or the `debugImage`
+// query was given, then the option would be pointed to the debug container
+func (s *Server) prepareDebug(c *gin.Context, cluster *Cluster, option *ExecOptions) error {
+	debug, _ := strconv.ParseBool(c.Query("debug"))
+	if !debug && c.Query("debugImage") == "" {
+		return nil
+	}
+	image, err := s.option.Policy.DebugImage(c.Query("debugImage"))
+	if err != nil {
+		zaplogger.Sugar().Error(err)
+		return err
+	}
+	name, err := CreateDebugContainer(cluster.Client(), option.Namespace, option.PodName, option.ContainerName, image, s.option.DebugTimeout)
+	if err != nil {
+		zaplogger.Sugar().Error(err)
+		return err
+	}
+	option.ContainerName = name
+	return nil
+}
+
+func (s *Server) NodeToken(c *gin.Context) {
+	if s.isDraining() {
+		c.JSON(http.StatusServiceUnavailable, HttpResponse{Code: CodeError, Message: ErrServerDraining})
+		return
+	}
+	if s.option.NodeDebug == nil || s.option.NodeDebug.Namespace == "" {
+		jsonError(c, fmt.Errorf("error: the node debug sessions were disabled"))
+		return
+	}
+	cluster, err := s.cluster(c)
+	if err != nil {
+		jsonError(c, err)
+		return
+	}
+	image, err := s.option.Policy.DebugImage(c.Query("debugImage"))
+	if err != nil {
+		zaplogger.Sugar().Error(err)
+		jsonError(c, err)
+		return
+	}
+	pod, err := CreateNodeDebugPod(cluster.Client(), s.option.NodeDebug, c.Param("node"), image, s.option.DebugTimeout)
+	if err != nil {
+		zaplogger.Sugar().Error(err)
+		jsonError(c, err)
+		return
+	}
+	option := &ExecOptions{
+		Kind:          SessionKindShell,
+		Namespace:     pod.Namespace,
+		PodName:       pod.Name,
+		ContainerName: nodeDebugContainerName,
+		Follow:        true,
+		Command:       []string{c.Param("command")},
+	}
+	session, err := cluster.SessionHub().New(option)
+	if err != nil {
+		DeleteNodeDebugPod(cluster.Client(), pod.Namespace, pod.Name)
+		jsonError(c, fmt.Errorf("Failed to init session err:%s", err.Error()))
+		return
+	}
+	go func() {
+		<-session.Ctx().Done()
+		DeleteNodeDebugPod(cluster.Client(), pod.Namespace, pod.Name)
+	}()
+	zaplogger.Sugar().Infof("Cluster:%s Node:%s Namespace:%s PodName:%s Command:%v", cluster.Name(), c.Param("node"), option.Namespace, option.PodName, option.Command)
+	c.JSON(http.StatusOK, HttpResponse{
+		Code:      CodeSuccess,
+		Token:     session.Id(),
+		Pod:       option.PodName,
+		Container: option.ContainerName,
+	})
+}
+
+func (s *Server) WorkloadToken(c *gin.Context) {
+	if s.isDraining() {
+		c.JSON(http.StatusServiceUnavailable, HttpResponse{Code: CodeError, Message: ErrServerDraining})
+		return
+	}
+	cluster, err := s.cluster(c)
+	if err != nil {
+		jsonError(c, err)
+		return
+	}
+	if err = s.checkNamespace(c); err != nil {
+		jsonError(c, err)
+		return
+	}
+	kind, strategy, ordinal, err := parseWorkload(c.Param("kind"), c.Query("strategy"), c.Query("ordinal"))
+	if err != nil {
+		zaplogger.Sugar().Error(err)
+		jsonError(c, err)
+		return
+	}
+	pod, err := ResolveWorkloadPod(cluster.Client(), cluster.SessionHub(), c.Param("namespace"), kind, c.Param("name"), strategy, ordinal)
+	if err != nil {
+		zaplogger.Sugar().Error(err)
+		jsonError(c, err)
+		return
+	}
+	option := &ExecOptions{
+		Kind:          SessionKindShell,
+		Namespace:     pod.Namespace,
+		PodName:       pod.Name,
+		ContainerName: c.Query("container"),
+		Follow:        true,
+		Command:       []string{c.Param("command")},
+	}
+	if option.ContainerName == "" {
+		option.ContainerName = DefaultContainer(pod)
+	}
+	if err = s.prepareDebug(c, cluster, option); err != nil {
+		jsonError(c, err)
+		return
+	}
+	session, err := cluster.SessionHub().New(option)
+	if err != nil {
+		jsonError(c, fmt.Errorf("Failed to init session err:%s", err.Error()))
+		return
+	}
+	zaplogger.Sugar().Infof("Cluster:%s Workload:%s/%s Strategy:%s Namespace:%s PodName:%s ContainerName:%s Command:%v",
+		cluster.Name(), kind, c.Param("name"), strategy, option.Namespace, option.PodName, option.ContainerName, option.Command)
+	c.JSON(http.StatusOK, HttpResponse{
+		Code:      CodeSuccess,
+		Token:     session.Id(),
+		Pod:       option.PodName,
+		Container: option.ContainerName,
+	})
+}
+
+// newProxy upgrades the request to the websocket by the ServerOptions
+func (s *Server) newProxy(c *gin.Context) (Proxy, error) {
+	return NewProxyWithOptions(context.Background(), c.Writer, c.Request, &ProxyOptions{
+		CompressionLevel: s.option.CompressionLevel,
+		WriteTimeout:     s.option.WriteTimeout,
+	})
+}
+
 func (s *Server) SSH(c *gin.Context) {
 	token := c.Param("token")
 	zaplogger.Sugar().Info("SSH token:", token)
-	proxy, err := NewProxy(context.Background(), c.Writer, c.Request)
+	cluster, err := s.cluster(c)
 	if err != nil {
-		zaplogger.Sugar().Error(err)
+		c.Abort()
+		return
+	}
+	session, err := s.session(cluster, token, SessionKindShell)
+	if err != nil {
+		jsonError(c, err)
 		return
 	}
-	session, err := s.sessionHub.Get(token)
+	proxy, err := s.newProxy(c)
 	if err != nil {
 		zaplogger.Sugar().Error(err)
 		return
 	}
+	session.Option().OutputFlushInterval = s.option.OutputFlushInterval
+	session.Option().WriteTimeout = s.option.WriteTimeout
+	session.Option().SlowConsumerPolicy = s.option.SlowConsumerPolicy
 	session.HandleSSH(proxy)
 }
 
-func (s *Server) LogStream(c *gin.Context) {
+func (s *Server) PortForwardToken(c *gin.Context) {
+	if s.isDraining() {
+		c.JSON(http.StatusServiceUnavailable, HttpResponse{Code: CodeError, Message: ErrServerDraining})
+		return
+	}
+	cluster, err := s.cluster(c)
+	if err != nil {
+		jsonError(c, err)
+		return
+	}
+	if err = s.checkNamespace(c); err != nil {
+		jsonError(c, err)
+		return
+	}
+	port, err := strconv.ParseUint(c.Param("port"), 10, 16)
+	if err != nil || port == 0 {
+		zaplogger.Sugar().Errorw("Convert port failed", "port", c.Param("port"), "err", err)
+		jsonError(c, fmt.Errorf("error: invalid port:%s", c.Param("port")))
+		return
+	}
+	option := &ExecOptions{
+		Kind:      SessionKindPortForward,
+		Namespace: c.Param("namespace"),
+		PodName:   c.Param("pod"),
+		Port:      int32(port),
+	}
+	session, err := cluster.SessionHub().New(option)
+	if err != nil {
+		jsonError(c, fmt.Errorf("Failed to init session err:%s", err.Error()))
+		return
+	}
+	zaplogger.Sugar().Infof("Cluster:%s PortForward Namespace:%s PodName:%s Port:%d", cluster.Name(), option.Namespace, option.PodName, option.Port)
+	c.JSON(http.StatusOK, HttpResponse{
+		Code:  CodeSuccess,
+		Token: session.Id(),
+		Pod:   option.PodName,
+	})
+}
+
+func (s *Server) PortForward(c *gin.Context) {
 	token := c.Param("token")
-	zaplogger.Sugar().Info("Log token:", token)
-	proxy, err := NewProxy(context.Background(), c.Writer, c.Request)
+	zaplogger.Sugar().Info("PortForward token:", token)
+	cluster, err := s.cluster(c)
+	if err != nil {
+		c.Abort()
+		return
+	}
+	session, err := s.session(cluster, token, SessionKindPortForward)
+	if err != nil {
+		c.Abort()
+		return
+	}
+	proxy, err := s.newProxy(c)
 	if err != nil {
 		zaplogger.Sugar().Error(err)
 		return
 	}
-	session, err := s.sessionHub.Get(token)
+	session.Option().OutputFlushInterval = s.option.OutputFlushInterval
+	session.Option().WriteTimeout = s.option.WriteTimeout
+	session.HandlePortForward(proxy)
+}
+
+// Upload streams the body into the `path` directory of the container,
+// the progress would be reported to the session of the `session` query if it was given
+func (s *Server) Upload(c *gin.Context) {
+	cluster, err := s.cluster(c)
+	if err != nil {
+		jsonError(c, err)
+		return
+	}
+	if err = s.checkNamespace(c); err != nil {
+		jsonError(c, err)
+		return
+	}
+	dir, err := s.option.Policy.UploadPath(c.Query("path"))
 	if err != nil {
 		zaplogger.Sugar().Error(err)
+		jsonError(c, err)
 		return
 	}
-	if err = setOptionWithSince(c, session.Option()); err != nil {
+	var session Session
+	if token := c.Query("session"); token != "" {
+		// only the shell session of the same pod could be notified of the progress
+		if session, err = s.session(cluster, token, SessionKindShell); err != nil {
+			jsonError(c, err)
+			return
+		}
+		if opt := session.Option(); opt.Namespace != c.Param("namespace") || opt.PodName != c.Param("pod") {
+			err = fmt.Errorf(ErrUploadSessionMismatch, token, c.Param("namespace"), c.Param("pod"))
+			zaplogger.Sugar().Error(err)
+			jsonError(c, err)
+			return
+		}
+	}
+	notify := func(msg *ControlMsg) {
+		if session == nil {
+			return
+		}
+		if err := session.Control(msg); err != nil {
+			zaplogger.Sugar().Error(err)
+		}
+	}
+	body := io.Reader(c.Request.Body)
+	if s.option.MaxUploadBytes > 0 {
+		body = http.MaxBytesReader(c.Writer, c.Request.Body, s.option.MaxUploadBytes)
+	}
+	progress := &progressReader{
+		r:     body,
+		total: c.Request.ContentLength,
+		report: func(n, total int64) {
+			notify(&ControlMsg{MsgType: ControlUploadProgress, Bytes: n, Total: total})
+		},
+	}
+	c.Request.Body = ioutil.NopCloser(progress)
+	option := &ExecOptions{
+		Namespace:     c.Param("namespace"),
+		PodName:       c.Param("pod"),
+		ContainerName: c.Param("container"),
+	}
+	zaplogger.Sugar().Infof("Cluster:%s Upload Namespace:%s PodName:%s ContainerName:%s Path:%s", cluster.Name(), option.Namespace, option.PodName, option.ContainerName, dir)
+	tarStream, err := newUploadTarStream(c.Request, c.Query("filename"))
+	if err == nil {
+		err = Upload(cluster.Client(), cluster.Config(), option, dir, tarStream)
+		_ = tarStream.Close()
+	}
+	if err != nil {
+		zaplogger.Sugar().Error(err)
+		notify(&ControlMsg{MsgType: ControlUploadFailed, Message: err.Error(), Bytes: progress.n, Total: progress.total})
+		jsonError(c, err)
+		return
+	}
+	notify(&ControlMsg{MsgType: ControlUploadCompleted, Message: dir, Bytes: progress.n, Total: progress.total})
+	c.JSON(http.StatusOK, HttpResponse{Code: CodeSuccess, Data: progress.n})
+}
+
+// FileDownload streams the `path` file or directory of the container as a tar or a zip archive
+func (s *Server) FileDownload(c *gin.Context) {
+	cluster, err := s.cluster(c)
+	if err != nil {
+		jsonError(c, err)
+		return
+	}
+	if err = s.checkNamespace(c); err != nil {
+		jsonError(c, err)
+		return
+	}
+	file, err := s.option.Policy.DownloadPath(c.Query("path"))
+	if err != nil {
+		zaplogger.Sugar().Error(err)
+		jsonError(c, err)
+		return
+	}
+	format := ArchiveFormat(c.DefaultQuery("format", string(ArchiveTar)))
+	contentType := ContentTypeTar
+	switch format {
+	case ArchiveTar:
+	case ArchiveZip:
+		contentType = "application/zip"
+	default:
+		jsonError(c, fmt.Errorf("error: the format:%s was not supported", format))
+		return
+	}
+	option := &ExecOptions{
+		Namespace:     c.Param("namespace"),
+		PodName:       c.Param("pod"),
+		ContainerName: c.Param("container"),
+	}
+	zaplogger.Sugar().Infof("Cluster:%s Download Namespace:%s PodName:%s ContainerName:%s Path:%s", cluster.Name(), option.Namespace, option.PodName, option.ContainerName, file)
+	name := path.Base(file)
+	if name == "/" {
+		name = "root"
+	}
+	fileContentDisposition := fmt.Sprintf("attachment;filename=%s_%s_%s_%s.%s", c.Param("namespace"), c.Param("pod"), c.Param("container"), name, format)
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Disposition", fileContentDisposition)
+	if err = Download(cluster.Client(), cluster.Config(), option, file, s.option.Policy.DownloadPaths, format, s.option.MaxDownloadBytes, c.Writer); err != nil {
+		zaplogger.Sugar().Error(err)
+		if !c.Writer.Written() {
+			c.Header("Content-Type", "")
+			c.Header("Content-Disposition", "")
+			jsonError(c, err)
+			return
+		}
+		abortResponse(c)
+	}
+}
+
+// fileTarget returns the cluster, the container option and the `path` which was allowed by Policy.DownloadPath
+func (s *Server) fileTarget(c *gin.Context) (*Cluster, *ExecOptions, string, error) {
+	cluster, err := s.cluster(c)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	if err = s.checkNamespace(c); err != nil {
+		return nil, nil, "", err
+	}
+	file, err := s.option.Policy.DownloadPath(c.Query("path"))
+	if err != nil {
+		zaplogger.Sugar().Error(err)
+		return nil, nil, "", err
+	}
+	option := &ExecOptions{
+		Namespace:     c.Param("namespace"),
+		PodName:       c.Param("pod"),
+		ContainerName: c.Param("container"),
+	}
+	return cluster, option, file, nil
+}
+
+func (s *Server) FileList(c *gin.Context) {
+	cluster, option, dir, err := s.fileTarget(c)
+	if err != nil {
+		jsonError(c, err)
+		return
+	}
+	files, err := ListDir(cluster.Client(), cluster.Config(), option, dir, s.option.Policy.DownloadPaths)
+	if err != nil {
+		zaplogger.Sugar().Error(err)
+		jsonError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, HttpResponse{Code: CodeSuccess, Data: files})
+}
+
+func (s *Server) FileStat(c *gin.Context) {
+	cluster, option, file, err := s.fileTarget(c)
+	if err != nil {
+		jsonError(c, err)
+		return
+	}
+	info, err := StatFile(cluster.Client(), cluster.Config(), option, file, s.option.Policy.DownloadPaths)
+	if err != nil {
+		zaplogger.Sugar().Error(err)
+		jsonError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, HttpResponse{Code: CodeSuccess, Data: info})
+}
+
+// FileRead responds the byte range of the file as application/octet-stream
+func (s *Server) FileRead(c *gin.Context) {
+	cluster, option, file, err := s.fileTarget(c)
+	if err != nil {
+		jsonError(c, err)
+		return
+	}
+	offset, err := strconv.ParseInt(c.DefaultQuery("offset", "0"), 10, 64)
+	if err != nil {
+		jsonError(c, fmt.Errorf("error: invalid offset:%s", c.Query("offset")))
+		return
+	}
+	length, err := strconv.ParseInt(c.DefaultQuery("length", strconv.Itoa(MaxFileReadBytes)), 10, 64)
+	if err != nil {
+		jsonError(c, fmt.Errorf("error: invalid length:%s", c.Query("length")))
+		return
+	}
+	c.Header("Content-Type", "application/octet-stream")
+	if err = ReadFile(cluster.Client(), cluster.Config(), option, file, s.option.Policy.DownloadPaths, offset, length, c.Writer); err != nil {
+		zaplogger.Sugar().Error(err)
+		if !c.Writer.Written() {
+			c.Header("Content-Type", "")
+			jsonError(c, err)
+			return
+		}
+		abortResponse(c)
+	}
+}
+
+func (s *Server) LogAggregateToken(c *gin.Context) {
+	if s.isDraining() {
+		c.JSON(http.StatusServiceUnavailable, HttpResponse{Code: CodeError, Message: ErrServerDraining})
+		return
+	}
+	cluster, err := s.cluster(c)
+	if err != nil {
+		jsonError(c, err)
+		return
+	}
+	if err = s.checkNamespace(c); err != nil {
+		jsonError(c, err)
+		return
+	}
+	format, err := parseLogFormat(c.Query("format"))
+	if err != nil {
+		jsonError(c, err)
+		return
+	}
+	selector, err := querySelector(c, cluster)
+	if err != nil {
+		jsonError(c, err)
+		return
+	}
+	option := &ExecOptions{
+		Kind:          SessionKindLog,
+		Namespace:     c.Param("namespace"),
+		Selector:      selector,
+		ContainerName: c.Query("container"),
+		LogFormat:     format,
+		Follow:        true,
+		// the envelope carries the timestamp of every line
+		Timestamps: format == LogFormatJSON,
+	}
+	setWorkloadEvents(c, option)
+	session, err := cluster.SessionHub().New(option)
+	if err != nil {
+		jsonError(c, fmt.Errorf("Failed to init session err:%s", err.Error()))
+		return
+	}
+	zaplogger.Sugar().Infof("Cluster:%s Namespace:%s Selector:%s ContainerName:%s Format:%s",
+		cluster.Name(), option.Namespace, option.Selector, option.ContainerName, option.LogFormat)
+	c.JSON(http.StatusOK, HttpResponse{Code: CodeSuccess, Token: session.Id()})
+}
+
+// EventsToken creates a token streaming the Events of the `pod` query, the pods matching the `selector` query,
+// or the workload of the `kind` and `name` queries and its pods through RouterPodLogStream
+func (s *Server) EventsToken(c *gin.Context) {
+	if s.isDraining() {
+		c.JSON(http.StatusServiceUnavailable, HttpResponse{Code: CodeError, Message: ErrServerDraining})
+		return
+	}
+	cluster, err := s.cluster(c)
+	if err != nil {
+		jsonError(c, err)
+		return
+	}
+	if err = s.checkNamespace(c); err != nil {
+		jsonError(c, err)
+		return
+	}
+	format, err := parseLogFormat(c.DefaultQuery("format", string(LogFormatJSON)))
+	if err != nil {
+		jsonError(c, err)
+		return
+	}
+	option := &ExecOptions{
+		Kind:      SessionKindLog,
+		Namespace: c.Param("namespace"),
+		PodName:   c.Query("pod"),
+		LogFormat: format,
+		Follow:    true,
+		Events:    true,
+	}
+	if option.PodName == "" {
+		if option.Selector, err = querySelector(c, cluster); err != nil {
+			jsonError(c, err)
+			return
+		}
+		setWorkloadEvents(c, option)
+	}
+	session, err := cluster.SessionHub().New(option)
+	if err != nil {
+		jsonError(c, fmt.Errorf("Failed to init session err:%s", err.Error()))
+		return
+	}
+	zaplogger.Sugar().Infof("Cluster:%s Events Namespace:%s PodName:%s Selector:%s Workload:%s/%s Format:%s",
+		cluster.Name(), option.Namespace, option.PodName, option.Selector, option.WorkloadKind, option.WorkloadName, option.LogFormat)
+	c.JSON(http.StatusOK, HttpResponse{Code: CodeSuccess, Token: session.Id()})
+}
+
+// setWorkloadEvents selects the Events of the workload of the `kind` and `name` queries,
+// the queries must have been validated by querySelector
+func setWorkloadEvents(c *gin.Context, option *ExecOptions) {
+	if kind := c.Query("kind"); kind != "" {
+		option.WorkloadKind = WorkloadKind(strings.ToLower(kind))
+		option.WorkloadName = c.Query("name")
+	}
+}
+
+// querySelector returns the label selector of the `selector` query,
+// or the selector of the workload of the `kind` and `name` queries
+func querySelector(c *gin.Context, cluster *Cluster) (string, error) {
+	selector := c.Query("selector")
+	if kind := c.Query("kind"); kind != "" {
+		k, _, _, err := parseWorkload(kind, "", "")
+		if err != nil {
+			return "", err
+		}
+		res, err := WorkloadSelector(cluster.Client(), c.Param("namespace"), k, c.Query("name"))
+		if err != nil {
+			zaplogger.Sugar().Error(err)
+			return "", err
+		}
+		return res.String(), nil
+	}
+	if _, err := labels.Parse(selector); err != nil || selector == "" {
+		return "", fmt.Errorf("error: invalid selector:%s", selector)
+	}
+	return selector, nil
+}
+
+func (s *Server) LogStream(c *gin.Context) {
+	token := c.Param("token")
+	zaplogger.Sugar().Info("Log token:", token)
+	cluster, err := s.cluster(c)
+	if err != nil {
 		c.Abort()
 		return
 	}
+	session, err := s.session(cluster, token, SessionKindShell, SessionKindLog)
+	if err != nil {
+		jsonError(c, err)
+		return
+	}
+	if err = s.setLogOptions(c, session.Option()); err != nil {
+		jsonError(c, err)
+		return
+	}
+	filter, err := NewLogFilter(LogFilterSpec{Include: c.Query("include"), Exclude: c.Query("exclude"), Field: c.Query("field")})
+	if err != nil {
+		zaplogger.Sugar().Error(err)
+		jsonError(c, err)
+		return
+	}
+	session.SetLogFilter(filter)
+	if v, ok := c.GetQuery("format"); ok {
+		format, err := parseLogFormat(v)
+		if err != nil {
+			jsonError(c, err)
+			return
+		}
+		session.Option().LogFormat = format
+	}
+	if session.Option().FollowRestarts, err = queryBool(c, "followRestarts", false); err != nil {
+		jsonError(c, err)
+		return
+	}
+	if session.Option().WithEvents, err = queryBool(c, "events", false); err != nil {
+		jsonError(c, err)
+		return
+	}
+	if (session.Option().FollowRestarts || session.Option().WithEvents) && session.Option().LogFormat == "" {
+		session.Option().LogFormat = LogFormatLine
+	}
+	if session.Option().LogFormat == LogFormatJSON {
+		// the envelope carries the timestamp of every line
+		if _, ok := c.GetQuery("timestamps"); ok && !session.Option().Timestamps {
+			jsonError(c, fmt.Errorf(ErrLogFormatJSONWithoutTimestamps))
+			return
+		}
+		session.Option().Timestamps = true
+	}
+	if session.Option().LogDropPolicy, err = parseLogDropPolicy(c.Query("dropPolicy")); err != nil {
+		jsonError(c, err)
+		return
+	}
+	session.Option().LogBufferBytes = s.option.LogBufferBytes
+	proxy, err := s.newProxy(c)
+	if err != nil {
+		zaplogger.Sugar().Error(err)
+		return
+	}
 	go session.HandleLog(proxy)
 }
 
+// setLogOptions sets the fields of corev1.PodLogOptions from the path segments and the queries:
+// `follow`, `previous`, `sinceSeconds`, `sinceTime`, `timestamps`, `tailLines`, `limitBytes` and `insecureSkipTLSVerifyBackend`,
+// the last one would be rejected unless ServerOptions.AllowInsecureSkipTLSVerifyBackend was set
+func (s *Server) setLogOptions(c *gin.Context, opt *ExecOptions) error {
+	if err := setOptionWithSince(c, opt); err != nil {
+		return err
+	}
+	var err error
+	if opt.Follow, err = queryBool(c, "follow", opt.Follow); err != nil {
+		return err
+	}
+	if opt.UsePreviousLogs, err = queryBool(c, "previous", opt.UsePreviousLogs); err != nil {
+		return err
+	}
+	if opt.Timestamps, err = queryBool(c, "timestamps", opt.Timestamps); err != nil {
+		return err
+	}
+	if opt.InsecureSkipTLSVerifyBackend, err = queryBool(c, "insecureSkipTLSVerifyBackend", opt.InsecureSkipTLSVerifyBackend); err != nil {
+		return err
+	}
+	if opt.InsecureSkipTLSVerifyBackend && !s.option.AllowInsecureSkipTLSVerifyBackend {
+		return fmt.Errorf(ErrInsecureSkipTLSVerifyBackendNotAllowed)
+	}
+	if v, err := queryInt64(c, "tailLines", 0); err != nil {
+		return err
+	} else if v != nil {
+		opt.TailLines = v
+	}
+	if v, err := queryInt64(c, "limitBytes", 1); err != nil {
+		return err
+	} else if v != nil {
+		opt.LimitBytes = v
+	}
+	return nil
+}
+
+// queryBool returns the bool value of the query, def would be returned if the query was absent
+func queryBool(c *gin.Context, key string, def bool) (bool, error) {
+	v, ok := c.GetQuery(key)
+	if !ok {
+		return def, nil
+	}
+	res, err := strconv.ParseBool(v)
+	if err != nil {
+		zaplogger.Sugar().Errorw("Convert query failed", key, v, "err", err)
+		return def, fmt.Errorf("error: invalid %s:%s", key, v)
+	}
+	return res, nil
+}
+
+// queryInt64 returns the int64 value of the query which must not be less than min, nil would be returned if the query was absent
+func queryInt64(c *gin.Context, key string, min int64) (*int64, error) {
+	v, ok := c.GetQuery(key)
+	if !ok {
+		return nil, nil
+	}
+	res, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || res < min {
+		zaplogger.Sugar().Errorw("Convert query failed", key, v, "err", err)
+		return nil, fmt.Errorf("error: invalid %s:%s, it must be an integer not less than %d", key, v, min)
+	}
+	return &res, nil
+}
+
+// setOptionWithSince sets the SinceSeconds or the SinceTime from the path segments,
+// the queries `sinceSeconds` and `sinceTime` would override the segments
 func setOptionWithSince(c *gin.Context, opt *ExecOptions) error {
-	// check `sinceSeconds` and `sinceTime`
-	sinceSec, err := strconv.Atoi(c.Param("SinceSeconds"))
+	sinceSeconds, sinceTime := c.Param("SinceSeconds"), c.Param("SinceTime")
+	if v, ok := c.GetQuery("sinceSeconds"); ok {
+		sinceSeconds = v
+	}
+	if v, ok := c.GetQuery("sinceTime"); ok {
+		sinceTime = v
+	}
+	seconds, t, err := parseSince(sinceSeconds, sinceTime)
 	if err != nil {
-		zaplogger.Sugar().Errorw("Convert sinceSeconds failed", "SinceSeconds", c.Param("SinceSeconds"), "err", err)
+		zaplogger.Sugar().Errorw("Convert since failed", "SinceSeconds", sinceSeconds, "SinceTime", sinceTime, "err", err)
 		return err
 	}
-	if sinceSec > 0 {
-		a := int64(sinceSec)
-		opt.SinceSeconds = &a
-		opt.SinceTime = nil
-	} else {
+	if seconds != nil || t != nil {
+		opt.SinceSeconds = seconds
+		opt.SinceTime = t
 	}
 	return nil
 }
 
 func (s *Server) LogDownload(c *gin.Context) {
+	cluster, err := s.cluster(c)
+	if err != nil {
+		c.Abort()
+		return
+	}
+	if err = s.checkNamespace(c); err != nil {
+		c.Abort()
+		return
+	}
 	pre, err := strconv.ParseBool(c.Param("previous"))
 	if err != nil {
 		zaplogger.Sugar().Error(err)
@@ -148,11 +1141,18 @@ func (s *Server) LogDownload(c *gin.Context) {
 		Follow:          false,
 		UsePreviousLogs: pre,
 	}
-	if err = setOptionWithSince(c, option); err != nil {
-		c.Abort()
+	if err = s.setLogOptions(c, option); err != nil {
+		jsonError(c, err)
+		return
+	}
+	// the download must not follow the stream
+	option.Follow = false
+	compression, contentEncoding, err := downloadCompression(c)
+	if err != nil {
+		jsonError(c, err)
 		return
 	}
-	reader, err := LogDownload(s.k8sClient, option)
+	reader, err := LogDownload(cluster.Client(), option)
 	if err != nil {
 		zaplogger.Sugar().Error(err)
 		c.Abort()
@@ -164,10 +1164,153 @@ func (s *Server) LogDownload(c *gin.Context) {
 			zaplogger.Sugar().Error(err)
 		}
 	}()
-	fileContentDisposition := fmt.Sprintf("attachment;filename=%s_%s_%s.log", c.Param("namespace"), c.Param("pod"), c.Param("container"))
-	c.Header("Content-Type", "text/plain")
-	c.Header("Content-Disposition", fileContentDisposition)
-	if _, err = io.Copy(c.Writer, reader); err != nil {
+	filename := fmt.Sprintf("%s_%s_%s.log", c.Param("namespace"), c.Param("pod"), c.Param("container"))
+	contentType := "text/plain"
+	if contentEncoding {
+		c.Header("Content-Encoding", string(compression))
+		c.Header("Vary", "Accept-Encoding")
+	} else if compression != CompressionNone {
+		filename += compression.Extension()
+		contentType = compression.ContentType(contentType)
+	}
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Disposition", "attachment;filename="+filename)
+	w, err := compressWriter(c.Writer, compression)
+	if err != nil {
+		zaplogger.Sugar().Error(err)
+		c.Abort()
+		return
+	}
+	if _, err = io.Copy(w, reader); err != nil {
+		// the compressor is never closed, its trailer would complete a truncated log
+		zaplogger.Sugar().Error(err)
+		abortResponse(c)
+	}
+	if err = w.Close(); err != nil {
+		zaplogger.Sugar().Error(err)
+		abortResponse(c)
+	}
+}
+
+// downloadCompression returns the compression of the `compress` query, or the one accepted by the Accept-Encoding
+// header as the Content-Encoding if the query was absent
+func downloadCompression(c *gin.Context) (compression Compression, contentEncoding bool, err error) {
+	if v, ok := c.GetQuery("compress"); ok {
+		compression, err = parseCompression(v)
+		return compression, false, err
+	}
+	compression = acceptEncoding(c.GetHeader("Accept-Encoding"))
+	return compression, compression != CompressionNone, nil
+}
+
+// LogArchive downloads the logs of the containers of the pod in a tar, gzip compressed by default
+func (s *Server) LogArchive(c *gin.Context) {
+	cluster, err := s.cluster(c)
+	if err != nil {
+		jsonError(c, err)
+		return
+	}
+	if err = s.checkNamespace(c); err != nil {
+		jsonError(c, err)
+		return
+	}
+	option := &ExecOptions{
+		Namespace:     c.Param("namespace"),
+		PodName:       c.Param("pod"),
+		ContainerName: c.Query("container"),
+	}
+	if err = s.setLogOptions(c, option); err != nil {
+		jsonError(c, err)
+		return
+	}
+	previous, err := queryBool(c, "previous", true)
+	if err != nil {
+		jsonError(c, err)
+		return
+	}
+	compression := CompressionGzip
+	if v, ok := c.GetQuery("compress"); ok {
+		if compression, err = parseCompression(v); err != nil {
+			jsonError(c, err)
+			return
+		}
+	}
+	pod, err := cluster.Client().CoreV1().Pods(option.Namespace).Get(c.Request.Context(), option.PodName, metav1.GetOptions{})
+	if err != nil {
+		zaplogger.Sugar().Error(err)
+		jsonError(c, err)
+		return
+	}
+	if _, err = logArchiveContainers(pod, option.ContainerName); err != nil {
+		jsonError(c, err)
+		return
+	}
+	zaplogger.Sugar().Infof("Cluster:%s LogArchive Namespace:%s PodName:%s ContainerName:%s Previous:%v", cluster.Name(), option.Namespace, option.PodName, option.ContainerName, previous)
+	c.Header("Content-Type", compression.ContentType(ContentTypeTar))
+	c.Header("Content-Disposition", fmt.Sprintf("attachment;filename=%s_%s_logs.tar%s", option.Namespace, option.PodName, compression.Extension()))
+	if err = writeTarArchive(c.Writer, compression, s.option.MaxDownloadBytes, func(tw *tar.Writer) error {
+		return WriteLogArchive(c.Request.Context(), cluster.Client(), option, pod, previous, s.option.MaxDownloadBytes, "", tw)
+	}); err != nil {
 		zaplogger.Sugar().Error(err)
+		if !c.Writer.Written() {
+			c.Header("Content-Type", "")
+			c.Header("Content-Disposition", "")
+			jsonError(c, err)
+			return
+		}
+		abortResponse(c)
+	}
+}
+
+// SupportBundle downloads the support bundle of the pods matching the selector in a tar, gzip compressed by default
+func (s *Server) SupportBundle(c *gin.Context) {
+	cluster, err := s.cluster(c)
+	if err != nil {
+		jsonError(c, err)
+		return
+	}
+	if err = s.checkNamespace(c); err != nil {
+		jsonError(c, err)
+		return
+	}
+	selector, err := querySelector(c, cluster)
+	if err != nil {
+		jsonError(c, err)
+		return
+	}
+	option := &ExecOptions{
+		Namespace: c.Param("namespace"),
+		Selector:  selector,
+	}
+	if err = s.setLogOptions(c, option); err != nil {
+		jsonError(c, err)
+		return
+	}
+	previous, err := queryBool(c, "previous", true)
+	if err != nil {
+		jsonError(c, err)
+		return
+	}
+	compression := CompressionGzip
+	if v, ok := c.GetQuery("compress"); ok {
+		if compression, err = parseCompression(v); err != nil {
+			jsonError(c, err)
+			return
+		}
+	}
+	zaplogger.Sugar().Infof("Cluster:%s SupportBundle Namespace:%s Selector:%s Previous:%v", cluster.Name(), option.Namespace, option.Selector, previous)
+	c.Header("Content-Type", compression.ContentType(ContentTypeTar))
+	c.Header("Content-Disposition", fmt.Sprintf("attachment;filename=%s_bundle_%s.tar%s", option.Namespace, time.Now().Format("20060102150405"), compression.Extension()))
+	if err = writeTarArchive(c.Writer, compression, s.option.MaxDownloadBytes, func(tw *tar.Writer) error {
+		return WriteSupportBundle(c.Request.Context(), cluster.Client(), option, previous, s.option.MaxBundlePods, s.option.MaxDownloadBytes, tw)
+	}); err != nil {
+		zaplogger.Sugar().Error(err)
+		if !c.Writer.Written() {
+			c.Header("Content-Type", "")
+			c.Header("Content-Disposition", "")
+			jsonError(c, err)
+			return
+		}
+		abortResponse(c)
 	}
 }
@@ -1,26 +1,114 @@
 package main
 
 import (
+	"compress/flate"
 	"context"
 	"flag"
 	"github.com/Shanghai-Lunara/pkg/zaplogger"
 	"github.com/TyrandeCloud/signals/pkg/signals"
 	exec "github.com/nevercase/k8s-exec-pod"
+	"os"
+	"strings"
+	"time"
 )
 
 func main() {
+	os.Exit(run())
+}
+
+// run returns the exit status of the binary
+func run() int {
 	var kubeconfig = flag.String("kubeconfig", "", "Path to a kubeconfig. Only required if out-of-cluster.")
 	var masterUrl = flag.String("master", "", "The address of the Kubernetes API server. Overrides any value in kubeconfig. Only required if out-of-cluster.")
+	var kubeconfigDir = flag.String("kubeconfigdir", "", "Path to a directory of kubeconfigs, every context inside would be loaded as a cluster.")
+	var contexts = flag.String("contexts", "", "Comma separated kubeconfig contexts to load. All contexts would be loaded if empty.")
+	var defaultCluster = flag.String("defaultcluster", "", "The cluster used by the routes without the cluster segment. Defaults to the current-context of kubeconfig.")
 	var proxyservice = flag.String("proxyservice", "0.0.0.0:9090", "The address of the http server.")
+	var drainTimeout = flag.Duration("draintimeout", time.Second*20, "How long the shutdown waits for the live sessions to end before closing them.")
+	var allowedNamespaces = flag.String("allowednamespaces", "", "Comma separated namespaces which are allowed to be accessed. All namespaces would be allowed if empty.")
+	var deniedNamespaces = flag.String("deniednamespaces", "", "Comma separated namespaces which are never allowed to be accessed.")
+	var debugImages = flag.String("debugimages", "", "Comma separated images allowed for the ephemeral debug containers, the first one is the default. Debug containers would be disabled if empty.")
+	var debugTimeout = flag.Duration("debugtimeout", time.Minute, "How long to wait for an ephemeral debug container to be running.")
+	var nodeDebugNamespace = flag.String("nodedebugnamespace", "", "The namespace of the privileged node debug pods. Node debug sessions would be disabled if empty.")
+	var nodeDebugLifetime = flag.Duration("nodedebuglifetime", time.Hour*4, "The max lifetime of a node debug pod.")
+	var instance = flag.String("instance", "", "The name of this server instance for labeling the node debug pods. Defaults to the hostname, the orphan pods are reaped by their heartbeat regardless of it.")
+	var uploadPaths = flag.String("uploadpaths", "", "Comma separated directories the files are allowed to be uploaded into. Uploads would be disabled if empty.")
+	var maxUploadBytes = flag.Int64("maxuploadbytes", 100<<20, "The max bytes of an upload, no limit if not positive.")
+	var downloadPaths = flag.String("downloadpaths", "", "Comma separated directories the files are allowed to be downloaded from. Downloads would be disabled if empty.")
+	var maxDownloadBytes = flag.Int64("maxdownloadbytes", 1<<30, "The max bytes of a download, no limit if not positive.")
+	var maxBundlePods = flag.Int("maxbundlepods", 100, "The max pods matched by a support bundle, no limit if not positive.")
+	var logBufferBytes = flag.Int("logbufferbytes", exec.DefaultLogBufferBytes, "The max bytes of the queued output of a log session for a slow or paused client.")
+	var compressionLevel = flag.Int("compressionlevel", flate.BestSpeed, "The permessage-deflate level of the websockets from -2 to 9, the compression would be disabled if 0.")
+	var outputFlushInterval = flag.Duration("outputflushinterval", exec.DefaultOutputFlushInterval, "How long the output of the exec and port forwarding sessions is batched into a frame, the batching would be disabled if negative.")
+	var writeTimeout = flag.Duration("writetimeout", exec.DefaultWriteTimeout, "The write deadline of the websocket messages, the slow consumer policy applies once the output was not queued within it.")
+	var allowInsecureBackend = flag.Bool("allowinsecurebackend", false, "Allow the log routes to skip verifying the serving certificate of the kubelet by the insecureSkipTLSVerifyBackend query.")
+	var slowConsumer = flag.String("slowconsumer", string(exec.SlowConsumerDisconnect), "What to do with the output of the exec and port forwarding sessions for a slow client, disconnect or drop. The drop covers the exec and attach sessions only, the port forwarding sessions are always disconnected.")
 	flag.Parse()
 	defer zaplogger.Sync()
 	stopCh := signals.SetupSignalHandler()
 	zaplogger.Sugar().Info("k8s-exec-pod is starting")
-	s := exec.InitServer(context.Background(), *proxyservice, *kubeconfig, *masterUrl)
+	var err error
+	if *instance == "" {
+		if *instance, err = os.Hostname(); err != nil {
+			zaplogger.Sugar().Error(err)
+			return 1
+		}
+	}
+	clusters, err := exec.LoadClusters(*masterUrl, *kubeconfig, *kubeconfigDir, splitList(*contexts), *defaultCluster)
+	if err != nil {
+		zaplogger.Sugar().Error(err)
+		return 1
+	}
+	s, ctx, err := exec.InitServer(context.Background(), &exec.ServerOptions{
+		Addr:         *proxyservice,
+		DrainTimeout: *drainTimeout,
+		Policy: &exec.Policy{
+			AllowedNamespaces: splitList(*allowedNamespaces),
+			DeniedNamespaces:  splitList(*deniedNamespaces),
+			DebugImages:       splitList(*debugImages),
+			UploadPaths:       splitList(*uploadPaths),
+			DownloadPaths:     splitList(*downloadPaths),
+		},
+		DebugTimeout:                      *debugTimeout,
+		MaxUploadBytes:                    *maxUploadBytes,
+		MaxDownloadBytes:                  *maxDownloadBytes,
+		MaxBundlePods:                     *maxBundlePods,
+		LogBufferBytes:                    *logBufferBytes,
+		CompressionLevel:                  *compressionLevel,
+		OutputFlushInterval:               *outputFlushInterval,
+		WriteTimeout:                      *writeTimeout,
+		SlowConsumerPolicy:                exec.SlowConsumerPolicy(*slowConsumer),
+		AllowInsecureSkipTLSVerifyBackend: *allowInsecureBackend,
+		NodeDebug: &exec.NodeDebugOptions{
+			Namespace:       *nodeDebugNamespace,
+			Instance:        *instance,
+			MaxLifetime:     *nodeDebugLifetime,
+			JanitorInterval: time.Minute,
+		},
+	}, clusters)
+	if err != nil {
+		zaplogger.Sugar().Error(err)
+		return 1
+	}
 	zaplogger.Sugar().Info("k8s-exec-pod is running")
-	<-stopCh
-	zaplogger.Sugar().Info("k8s-exec-pod trigger shutdown")
-	s.ShutDown()
-	<-stopCh
+	select {
+	case <-stopCh:
+		zaplogger.Sugar().Info("k8s-exec-pod trigger shutdown")
+	case <-ctx.Done():
+		zaplogger.Sugar().Error("k8s-exec-pod stopped unexpectedly, trigger shutdown")
+	}
+	if err = s.ShutDown(); err != nil {
+		zaplogger.Sugar().Errorf("k8s-exec-pod shutdown err:%v", err)
+		return 1
+	}
 	zaplogger.Sugar().Info("k8s-exec-pod shutdown gracefully")
+	return 0
+}
+
+// splitList splits the comma separated flag value, nil would be returned if it was empty
+func splitList(v string) []string {
+	if v == "" {
+		return nil
+	}
+	return strings.Split(v, ",")
 }
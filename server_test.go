@@ -0,0 +1,242 @@
+package k8s_exec_pod
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/websocket"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const fakeClusterName = "fake"
+
+func newFakeServer(t *testing.T, drainTimeout time.Duration, objects ...runtime.Object) (*Server, context.Context) {
+	return newFakeServerWithOptions(t, &ServerOptions{DrainTimeout: drainTimeout}, objects...)
+}
+
+func newFakeServerWithOptions(t *testing.T, option *ServerOptions, objects ...runtime.Object) (*Server, context.Context) {
+	clusters, err := NewClusterHub("", NewCluster(fakeClusterName, &rest.Config{}, fake.NewSimpleClientset(objects...)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	option.Addr = "127.0.0.1:0"
+	s, ctx, err := InitServer(context.Background(), option, clusters)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, ctx
+}
+
+func getJSON(t *testing.T, url string, v interface{}) {
+	res, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if err = json.NewDecoder(res.Body).Decode(v); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func getToken(t *testing.T, s *Server) string {
+	var res HttpResponse
+	getJSON(t, fmt.Sprintf("http://%s/cluster/%s/namespace/default/pod/pod-0/shell/app/bash", s.Addr(), fakeClusterName), &res)
+	if res.Code != CodeSuccess || res.Token == "" {
+		t.Fatalf("unexpected token response: %+v", res)
+	}
+	return res.Token
+}
+
+func sessionCount(s *Server) int {
+	count := 0
+	for _, cluster := range s.clusters.List() {
+		count += len(cluster.SessionHub().List())
+	}
+	return count
+}
+
+func TestServerShutDown(t *testing.T) {
+	s, ctx := newFakeServer(t, time.Millisecond*300)
+
+	// a log session streams the fake logs and then gets closed by the server
+	token := getToken(t, s)
+	u := fmt.Sprintf("ws://%s/cluster/%s/log/sinceSeconds/0/sinceTime/0/token/%s", s.Addr(), fakeClusterName, token)
+	ws, _, err := websocket.DefaultDialer.Dial(u, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	messageType, data, err := ws.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if messageType != websocket.BinaryMessage || string(data) != "fake logs" {
+		t.Fatalf("unexpected message type:%d data:%q", messageType, data)
+	}
+	if _, _, err = ws.ReadMessage(); !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+		t.Fatalf("expected a normal close frame, got err:%v", err)
+	}
+
+	// a pending session would never be connected, it must be closed by force after the drain timeout
+	getToken(t, s)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- s.ShutDown()
+	}()
+	select {
+	case err = <-done:
+		if err != nil {
+			t.Fatalf("unexpected shutdown err:%v", err)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("shutdown was not completed in time")
+	}
+	select {
+	case <-ctx.Done():
+	default:
+		t.Fatal("the server context was not done after shutdown")
+	}
+	if n := sessionCount(s); n != 0 {
+		t.Fatalf("expected all the sessions were drained, %d left", n)
+	}
+	if _, err = http.Get(fmt.Sprintf("http://%s%s", s.Addr(), RouterClusterList)); err == nil {
+		t.Fatal("expected the listener was closed after shutdown")
+	}
+}
+
+func TestServerShutDownRejectsNewTokens(t *testing.T) {
+	s, _ := newFakeServer(t, time.Second)
+	getToken(t, s)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- s.ShutDown()
+	}()
+	deadline := time.After(time.Second * 2)
+	for !s.isDraining() {
+		select {
+		case <-deadline:
+			t.Fatal("the server was not draining")
+		case <-time.After(time.Millisecond * 10):
+		}
+	}
+	var res HttpResponse
+	getJSON(t, fmt.Sprintf("http://%s/namespace/default/pod/pod-0/shell/app/bash", s.Addr()), &res)
+	if res.Code != CodeError || res.Message != ErrServerDraining {
+		t.Fatalf("unexpected token response while draining: %+v", res)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("unexpected shutdown err:%v", err)
+	}
+}
+
+func TestServerEachSessionConcurrently(t *testing.T) {
+	s, _ := newFakeServer(t, time.Millisecond*100)
+	defer s.ShutDown()
+	for i := 0; i < 3; i++ {
+		getToken(t, s)
+	}
+
+	// every call stalls longer than the timeout, they must be waited together instead of one by one
+	release := make(chan struct{})
+	defer close(release)
+	var calls int32
+	start := time.Now()
+	if s.eachSession(time.Millisecond*200, func(cluster *Cluster, session Session) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+	}) {
+		t.Fatal("expected the stalled calls were not returned in time")
+	}
+	if elapsed := time.Since(start); elapsed > time.Millisecond*500 {
+		t.Fatalf("eachSession was not bounded by the timeout, elapsed:%v", elapsed)
+	}
+	if n := atomic.LoadInt32(&calls); n != 3 {
+		t.Fatalf("expected 3 sessions were called concurrently, got %d", n)
+	}
+
+	if !s.eachSession(time.Second, func(cluster *Cluster, session Session) {}) {
+		t.Fatal("expected the calls returned in time")
+	}
+}
+
+func TestClusterList(t *testing.T) {
+	s, _ := newFakeServer(t, time.Millisecond*100)
+	defer s.ShutDown()
+	var res struct {
+		Code int             `json:"code"`
+		Data []ClusterStatus `json:"data"`
+	}
+	getJSON(t, fmt.Sprintf("http://%s%s", s.Addr(), RouterClusterList), &res)
+	if res.Code != CodeSuccess || len(res.Data) != 1 {
+		t.Fatalf("unexpected cluster list: %+v", res)
+	}
+	if status := res.Data[0]; status.Name != fakeClusterName || !status.Default || !status.Reachable {
+		t.Fatalf("unexpected cluster status: %+v", status)
+	}
+}
+
+func TestSessionKind(t *testing.T) {
+	s, _ := newFakeServer(t, time.Millisecond*100)
+	defer s.ShutDown()
+	tokens := make(map[SessionKind]string)
+	for kind, route := range map[SessionKind]string{
+		SessionKindShell:       "/namespace/default/pod/pod-0/shell/app/bash",
+		SessionKindPortForward: "/namespace/default/pod/pod-0/portforward/8080",
+		SessionKindLog:         "/namespace/default/events?pod=pod-0",
+	} {
+		var res HttpResponse
+		getJSON(t, fmt.Sprintf("http://%s%s", s.Addr(), route), &res)
+		if res.Code != CodeSuccess || res.Token == "" {
+			t.Fatalf("unexpected %s token response: %+v", kind, res)
+		}
+		tokens[kind] = res.Token
+	}
+
+	routes := map[string][]SessionKind{
+		"/ssh/%s":         {SessionKindShell},
+		"/portforward/%s": {SessionKindPortForward},
+		"/log/sinceSeconds/0/sinceTime/0/token/%s": {SessionKindShell, SessionKindLog},
+	}
+	for route, allowed := range routes {
+		for kind, token := range tokens {
+			ok := false
+			for _, v := range allowed {
+				ok = ok || v == kind
+			}
+			if ok {
+				continue
+			}
+			// the mismatched token must be rejected before the upgrade, leaving the session untouched
+			u := fmt.Sprintf("ws://%s"+route, s.Addr(), token)
+			if ws, _, err := websocket.DefaultDialer.Dial(u, nil); err == nil {
+				ws.Close()
+				t.Fatalf("expected the %s token was rejected by %s", kind, route)
+			}
+			if _, err := s.clusters.List()[0].SessionHub().Get(token); err != nil {
+				t.Fatalf("expected the %s session was left after rejected by %s, err:%v", kind, route, err)
+			}
+		}
+	}
+}
+
+func TestTerminalEmptyCommand(t *testing.T) {
+	sessionHub := NewSessionHub(fake.NewSimpleClientset(), &rest.Config{})
+	session, err := sessionHub.New(&ExecOptions{Kind: SessionKindShell, Namespace: "default", PodName: "pod-0", ContainerName: "app"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	Terminal(fake.NewSimpleClientset(), &rest.Config{}, session)
+	select {
+	case <-session.Ctx().Done():
+	case <-time.After(time.Second):
+		t.Fatal("expected the session was closed for the empty command")
+	}
+}
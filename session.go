@@ -32,17 +32,22 @@ type Session interface {
 	Wait()
 	HandleLog(p Proxy)
 	HandleSSH(p Proxy)
+	HandlePortForward(p Proxy)
 	Option() *ExecOptions
 	Close(reason string)
+	Control(msg *ControlMsg) error
 	Ctx() context.Context
 	ReadCloser(rc io.ReadCloser)
+	LogFilter() *LogFilter
+	SetLogFilter(f *LogFilter)
 }
 
 const (
-	ReasonProcessExited = "process exited"
-	ReasonStreamStopped = "stream stopped"
-	ReasonConnTimeout   = "conn wait timeout"
-	ReasonContextCancel = "ctx cancel"
+	ReasonProcessExited  = "process exited"
+	ReasonStreamStopped  = "stream stopped"
+	ReasonConnTimeout    = "conn wait timeout"
+	ReasonContextCancel  = "ctx cancel"
+	ReasonServerShutdown = "server shutdown"
 )
 
 // NewSession returns a new Session Interface
@@ -57,7 +62,7 @@ func NewSession(ctx context.Context, connTimeout int64, k8sClient kubernetes.Int
 		connTimeout: connTimeout,
 		option:      option,
 		startChan:   make(chan proxyChan, 1),
-		sizeChan:    make(chan remotecommand.TerminalSize),
+		sizeChan:    make(chan remotecommand.TerminalSize, 1),
 		k8sClient:   k8sClient,
 		cfg:         cfg,
 		context:     subCtx,
@@ -72,6 +77,8 @@ type handleType string
 const (
 	handleSSH handleType = "ssh"
 	handleLog handleType = "log"
+	// handlePortForward tunnels the websocket.BinaryMessage to the port of the pod
+	handlePortForward handleType = "portforward"
 )
 
 type proxyChan struct {
@@ -87,12 +94,21 @@ type session struct {
 
 	option *ExecOptions
 
+	// sizeChan keeps the latest resize until Next took it, nobody calls Next if the process has no tty
 	sizeChan chan remotecommand.TerminalSize
 
 	readCloser io.ReadCloser
 
+	logFilter   *LogFilter
+	logFilterMu sync.RWMutex
+	// logBuffer queues the output of a log session, it was guarded by the proxyMu
+	logBuffer *logBuffer
+	// output coalesces the output of the other sessions, it was guarded by the proxyMu
+	output *outputBuffer
+
 	startChan      chan proxyChan
 	websocketProxy Proxy
+	proxyMu        sync.RWMutex
 
 	k8sClient kubernetes.Interface
 	cfg       *rest.Config
@@ -112,7 +128,25 @@ func (s *session) Wait() {
 		s.Close(ReasonConnTimeout)
 		return
 	case proxyChan := <-s.startChan:
+		s.proxyMu.Lock()
 		s.websocketProxy = proxyChan.p
+		if proxyChan.t == handleLog {
+			s.logBuffer = newLogBuffer(s.option.LogBufferBytes, s.option.LogDropPolicy, proxyChan.p.Send)
+			go s.logBuffer.run()
+		} else {
+			policy := s.option.SlowConsumerPolicy
+			if proxyChan.t == handlePortForward {
+				// dropping would corrupt the tunneled stream and inject the control messages into it,
+				// a slow client of the port forwarding is always disconnected
+				policy = SlowConsumerDisconnect
+			}
+			s.output = newOutputBuffer(s.option.OutputFlushInterval, s.option.WriteTimeout, policy,
+				proxyChan.p.SendTimeout, func(err error) {
+					zaplogger.Sugar().Error(err)
+					go s.Close(err.Error())
+				})
+		}
+		s.proxyMu.Unlock()
 		switch proxyChan.t {
 		case handleSSH:
 			Terminal(s.k8sClient, s.cfg, s)
@@ -122,27 +156,36 @@ func (s *session) Wait() {
 					var buf []byte
 					if _, err := s.Read(buf); err != nil {
 						zaplogger.Sugar().Error(err)
-						if s.readCloser != nil {
-							zaplogger.Sugar().Info("readCloser was not set")
-							return
-						}
-						go func() {
-							defer func() {
-								if r := recover(); r != nil {
-									zaplogger.Sugar().Info("s.readCloser.Close Recovered in: ", r)
-								}
-							}()
-							if err = s.readCloser.Close(); err != nil {
-								zaplogger.Sugar().Error(err)
-							}
-						}()
+						// the log streams were opened with the ctx of the session, closing the session releases them
+						s.Close(err.Error())
 						return
 					}
 				}
 			}()
-			if err := LogTransmit(s.k8sClient, s); err != nil {
+			transmit := LogTransmit
+			switch {
+			case s.option.Events:
+				transmit = EventTransmit
+			case s.option.Selector != "":
+				transmit = AggregateLogTransmit
+			}
+			if s.option.WithEvents && !s.option.Events {
+				go func() {
+					if err := watchEvents(s.k8sClient, s); err != nil {
+						zaplogger.Sugar().Errorw("watch the events of the log session failed", "session", s.Id(), "err", err)
+					}
+				}()
+			}
+			if err := transmit(s.k8sClient, s); err != nil {
 				zaplogger.Sugar().Error(err)
 			}
+		case handlePortForward:
+			if err := PortForward(s.k8sClient, s.cfg, s, proxyChan.p); err != nil {
+				zaplogger.Sugar().Error(err)
+				s.Close(err.Error())
+				return
+			}
+			s.Close(ReasonStreamStopped)
 		}
 	case <-s.context.Done():
 		return
@@ -167,6 +210,15 @@ func (s *session) HandleSSH(p Proxy) {
 	}
 }
 
+func (s *session) HandlePortForward(p Proxy) {
+	select {
+	case s.startChan <- proxyChan{t: handlePortForward, p: p}:
+		return
+	case <-time.After(time.Second * 1):
+		return
+	}
+}
+
 const EndOfTransmission = "\u0004"
 
 // Read handles pty->process messages (stdin, resize)
@@ -187,21 +239,38 @@ func (s *session) Read(p []byte) (int, error) {
 		return copy(p, EndOfTransmission), err
 	}
 
-	var msg TermMsg
-	if err := json.Unmarshal(wsMsg.data, &msg); err != nil {
+	msg, input, err := parseTermMsg(s.websocketProxy.Binary(), wsMsg)
+	if err != nil {
 		zaplogger.Sugar().Error(err)
 		return copy(p, EndOfTransmission), err
 	}
 
 	switch msg.MsgType {
 	case TermResize:
-		s.sizeChan <- remotecommand.TerminalSize{Width: msg.Cols, Height: msg.Rows}
+		s.resize(remotecommand.TerminalSize{Width: msg.Cols, Height: msg.Rows})
 		return 0, nil
 	case TermInput:
-		return s.websocketProxy.HandleInput(p, []byte(msg.Input))
+		return s.websocketProxy.HandleInput(p, input)
 	case TermPing:
 		s.websocketProxy.HandlePing()
 		return 0, nil
+	case TermPause, TermResume:
+		if s.logBuffer != nil {
+			s.logBuffer.setPaused(msg.MsgType == TermPause)
+		}
+		return 0, nil
+	case TermFilter:
+		var spec LogFilterSpec
+		if msg.Filter != nil {
+			spec = *msg.Filter
+		}
+		f, err := NewLogFilter(spec)
+		if err != nil {
+			zaplogger.Sugar().Error(err)
+			return 0, s.Control(&ControlMsg{MsgType: ControlLogFilterFailed, Message: err.Error()})
+		}
+		s.SetLogFilter(f)
+		return 0, s.Control(&ControlMsg{MsgType: ControlLogFilterApplied})
 	default:
 		return copy(p, EndOfTransmission), fmt.Errorf("unknown message type '%s'", msg.MsgType)
 	}
@@ -212,8 +281,23 @@ func (s *session) Read(p []byte) (int, error) {
 // If the TermMsg.MsgType was TermPing, then it would handle Proxy.HandlePing
 func (s *session) Write(p []byte) (int, error) {
 	//zaplogger.Sugar().Infow("TerminalSession", "Write", string(p))
+	if s.output != nil {
+		// the output is copied by the buffer
+		if err := s.output.write(p); err != nil {
+			zaplogger.Sugar().Error(err)
+			return 0, err
+		}
+		return len(p), nil
+	}
 	data := make([]byte, len(p))
 	copy(data, p)
+	if s.logBuffer != nil {
+		// the log streams must not be blocked by a slow or paused client
+		if err := s.logBuffer.push(data); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
 	if err := s.websocketProxy.Send(websocket.BinaryMessage, data); err != nil {
 		zaplogger.Sugar().Error(err)
 		return 0, err
@@ -221,6 +305,21 @@ func (s *session) Write(p []byte) (int, error) {
 	return len(p), nil
 }
 
+// resize queues the size for Next without blocking the Read, the pending size would be replaced by the latest one
+func (s *session) resize(size remotecommand.TerminalSize) {
+	for {
+		select {
+		case s.sizeChan <- size:
+			return
+		default:
+		}
+		select {
+		case <-s.sizeChan:
+		default:
+		}
+	}
+}
+
 // Next handles pty->process resize events
 // Called in a loop from remotecommand as long as the process is running
 func (s *session) Next() *remotecommand.TerminalSize {
@@ -240,10 +339,52 @@ func (s *session) Close(reason string) {
 	zaplogger.Sugar().Infow("TerminalSession trigger close", "sessionId", s.Id(), "reason", reason)
 	s.once.Do(func() {
 		zaplogger.Sugar().Infow("TerminalSession successfully close", "sessionId", s.Id(), "reason", reason)
+		s.proxyMu.RLock()
+		defer s.proxyMu.RUnlock()
+		if s.logBuffer != nil {
+			// flush the queued output before the close frame
+			s.logBuffer.close(closeFlushTimeout)
+		}
+		if s.output != nil {
+			// flush the coalesced output before the close frame, the failure was logged by Write
+			_ = s.output.flush(closeFlushTimeout)
+		}
 		s.cancel()
+		if s.websocketProxy == nil {
+			return
+		}
+		code := websocket.CloseNormalClosure
+		if reason == ReasonServerShutdown {
+			code = websocket.CloseGoingAway
+		}
+		s.websocketProxy.CloseWithReason(code, reason)
 	})
 }
 
+// Control sends the ControlMsg to the client as a websocket.TextMessage
+func (s *session) Control(msg *ControlMsg) error {
+	s.proxyMu.RLock()
+	defer s.proxyMu.RUnlock()
+	if s.websocketProxy == nil {
+		return fmt.Errorf("error: the session:%s was not connected", s.Id())
+	}
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	if s.logBuffer != nil {
+		// keep the order of the control messages and the output of the log session
+		return s.logBuffer.pushControl(data)
+	}
+	if s.output != nil {
+		// keep the order of the control messages and the coalesced output
+		if err = s.output.flush(s.output.timeout); err != nil {
+			return err
+		}
+	}
+	return s.websocketProxy.Send(websocket.TextMessage, data)
+}
+
 func (s *session) Ctx() context.Context {
 	return s.context
 }
@@ -252,6 +393,19 @@ func (s *session) ReadCloser(rc io.ReadCloser) {
 	s.readCloser = rc
 }
 
+// LogFilter returns the filter of the log stream, nil means every line would be kept
+func (s *session) LogFilter() *LogFilter {
+	s.logFilterMu.RLock()
+	defer s.logFilterMu.RUnlock()
+	return s.logFilter
+}
+
+func (s *session) SetLogFilter(f *LogFilter) {
+	s.logFilterMu.Lock()
+	defer s.logFilterMu.Unlock()
+	s.logFilter = f
+}
+
 // genTerminalSessionId generates a random session ID string. The format is not really interesting.
 // This ID is used to identify the session when the client opens the Websocket connection.
 // Not the same as the Websocket session id! We can't use that as that is generated
@@ -16,6 +16,7 @@ const (
 type SessionHub interface {
 	New(option *ExecOptions) (s Session, err error)
 	Get(sessionId string) (s Session, err error)
+	List() []Session
 	Close(sessionId string, reason string) error
 	Listen(session Session) error
 }
@@ -61,6 +62,16 @@ func (sh *sessionHub) Get(sessionId string) (Session, error) {
 	return nil, fmt.Errorf(ErrSessionIdNotExist, sessionId)
 }
 
+func (sh *sessionHub) List() []Session {
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+	res := make([]Session, 0, len(sh.items))
+	for _, t := range sh.items {
+		res = append(res, t)
+	}
+	return res
+}
+
 func (sh *sessionHub) Close(sessionId string, reason string) error {
 	sh.mu.Lock()
 	defer sh.mu.Unlock()
@@ -0,0 +1,165 @@
+package k8s_exec_pod
+
+import (
+	"context"
+	"github.com/gorilla/websocket"
+	"io/ioutil"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestSessionResizeWithoutTTY covers an attach to a container without tty, where remotecommand keeps reading the
+// stdin but never calls Next, a resize from the client must not block the following input
+func TestSessionResizeWithoutTTY(t *testing.T) {
+	sess, err := NewSession(context.Background(), 60, fake.NewSimpleClientset(), &rest.Config{}, &ExecOptions{
+		Namespace:     "default",
+		PodName:       "pod-0",
+		ContainerName: "app",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := sess.(*session)
+	defer s.Close(ReasonStreamStopped)
+
+	inputs := make(chan string, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p, err := NewProxy(s.Ctx(), w, r)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		s.websocketProxy = p
+		buf := make([]byte, 32)
+		for {
+			n, err := s.Read(buf)
+			if err != nil {
+				return
+			}
+			if n > 0 {
+				inputs <- string(buf[:n])
+				return
+			}
+		}
+	}))
+	defer srv.Close()
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+
+	for _, msg := range []TermMsg{
+		{MsgType: TermResize, Cols: 80, Rows: 24},
+		{MsgType: TermResize, Cols: 120, Rows: 40},
+		{MsgType: TermInput, Input: "ls\n"},
+	} {
+		if err = ws.WriteJSON(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	select {
+	case input := <-inputs:
+		if input != "ls\n" {
+			t.Fatalf("unexpected input:%q", input)
+		}
+	case <-time.After(time.Second * 3):
+		t.Fatal("the input was blocked by the resizes")
+	}
+
+	// only the latest size was kept for a tty which would call Next later
+	if size := s.Next(); size == nil || size.Width != 120 || size.Height != 40 {
+		t.Fatalf("unexpected size:%+v", size)
+	}
+}
+
+// TestPortForwardSlowConsumerPolicy covers a port forwarding session of a server configured to drop the output of
+// a slow client, the tunnel must be disconnected instead
+func TestPortForwardSlowConsumerPolicy(t *testing.T) {
+	var sess Session
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !websocket.IsWebSocketUpgrade(r) {
+			// the port forwarding of the pod fails once the output was set up
+			http.Error(w, "portforward is not supported", http.StatusInternalServerError)
+			return
+		}
+		p, err := NewProxy(sess.Ctx(), w, r)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		sess.HandlePortForward(p)
+		<-sess.Ctx().Done()
+	}))
+	defer srv.Close()
+	cfg := &rest.Config{Host: srv.URL}
+	k8sClient, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sess, err = NewSession(context.Background(), 60, k8sClient, cfg, &ExecOptions{
+		Kind:               SessionKindPortForward,
+		Namespace:          "default",
+		PodName:            "pod-0",
+		Port:               8080,
+		SlowConsumerPolicy: SlowConsumerDrop,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	select {
+	case <-sess.Ctx().Done():
+	case <-time.After(time.Second * 3):
+		t.Fatal("expected the session was closed once the port forwarding failed")
+	}
+
+	s := sess.(*session)
+	s.proxyMu.RLock()
+	defer s.proxyMu.RUnlock()
+	if s.output == nil || s.output.policy != SlowConsumerDisconnect {
+		t.Fatalf("expected the slow client of the port forwarding was disconnected, got %+v", s.output)
+	}
+}
+
+func TestPortForwardNormalClose(t *testing.T) {
+	errChan := make(chan error, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p, err := NewProxy(context.Background(), w, r)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		errChan <- forwardRecv(p, nopWriteCloser{ioutil.Discard})
+	}))
+	defer srv.Close()
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	if err = ws.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err = <-errChan:
+		// the normal close of the client must stop the tunnel with the ReasonStreamStopped
+		if !isNormalClose(err) {
+			t.Fatalf("expected the normal close of the client, got %v", err)
+		}
+	case <-time.After(time.Second * 3):
+		t.Fatal("expected the tunnel was stopped once the client closed")
+	}
+	if isNormalClose(&websocket.CloseError{Code: websocket.CloseAbnormalClosure}) {
+		t.Fatal("expected the abnormal close was not normal")
+	}
+}
@@ -0,0 +1,140 @@
+package k8s_exec_pod
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/Shanghai-Lunara/pkg/zaplogger"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/yaml"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	ErrBundleTooManyPods = "error: the bundle matched %d pods, more than the limit of %d"
+)
+
+// NodeAssignment is the node which the pod was scheduled onto
+type NodeAssignment struct {
+	Pod    string          `json:"pod"`
+	Node   string          `json:"node"`
+	HostIP string          `json:"hostIP,omitempty"`
+	PodIP  string          `json:"podIP,omitempty"`
+	Phase  corev1.PodPhase `json:"phase"`
+}
+
+// WriteSupportBundle writes the support bundle of the pods matching ExecOptions.Selector in ExecOptions.Namespace
+// into the tar:
+//
+//	pods/<pod>/pod.yaml          the spec and the status of the pod
+//	pods/<pod>/logs/*.log        the logs written by WriteLogArchive
+//	events.yaml                  the Events of the pods, the oldest first
+//	nodes.yaml                   the NodeAssignments of the pods
+//
+// A file which could not be collected is replaced by a `.error` file carrying the error.
+// The bundle would be rejected before anything was written if more than maxPods pods were matched, and every log
+// would be read up to maxBytes + 1 bytes as WriteLogArchive does, no limit if they were not positive.
+// It stops once the ctx was done or the tar failed to be written, e.g. the client was gone.
+func WriteSupportBundle(ctx context.Context, k8sClient kubernetes.Interface, option *ExecOptions, previous bool, maxPods int, maxBytes int64, tw *tar.Writer) error {
+	list, err := k8sClient.CoreV1().Pods(option.Namespace).List(ctx, metav1.ListOptions{LabelSelector: option.Selector})
+	if err != nil {
+		return err
+	}
+	if maxPods > 0 && len(list.Items) > maxPods {
+		return fmt.Errorf(ErrBundleTooManyPods, len(list.Items), maxPods)
+	}
+	sort.Slice(list.Items, func(i, j int) bool {
+		return list.Items[i].Name < list.Items[j].Name
+	})
+	pods := make(map[string]bool, len(list.Items))
+	nodes := make([]NodeAssignment, 0, len(list.Items))
+	for i := range list.Items {
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+		pod := &list.Items[i]
+		pods[pod.Name] = true
+		nodes = append(nodes, NodeAssignment{
+			Pod:    pod.Name,
+			Node:   pod.Spec.NodeName,
+			HostIP: pod.Status.HostIP,
+			PodIP:  pod.Status.PodIP,
+			Phase:  pod.Status.Phase,
+		})
+		dir := "pods/" + pod.Name + "/"
+		if err = writeBundleYaml(tw, dir+"pod.yaml", podManifest(pod), nil); err != nil {
+			return err
+		}
+		opt := *option
+		opt.ContainerName = ""
+		if err = WriteLogArchive(ctx, k8sClient, &opt, pod, previous, maxBytes, dir+"logs/", tw); err != nil {
+			return err
+		}
+	}
+	events, err := k8sClient.CoreV1().Events(option.Namespace).List(ctx, metav1.ListOptions{})
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if err != nil {
+		zaplogger.Sugar().Errorw("WriteSupportBundle list events failed", "namespace", option.Namespace, "err", err)
+	} else {
+		events.Items = podEvents(events.Items, pods)
+		events.ListMeta = metav1.ListMeta{}
+		events.APIVersion, events.Kind = "v1", "EventList"
+	}
+	if err = writeBundleYaml(tw, "events.yaml", events, err); err != nil {
+		return err
+	}
+	return writeBundleYaml(tw, "nodes.yaml", nodes, nil)
+}
+
+// podManifest returns the copy of the pod without the managed fields for the bundle
+func podManifest(pod *corev1.Pod) *corev1.Pod {
+	res := pod.DeepCopy()
+	res.APIVersion, res.Kind = "v1", "Pod"
+	res.ManagedFields = nil
+	return res
+}
+
+// podEvents returns the events of the pods, the oldest first
+func podEvents(events []corev1.Event, pods map[string]bool) []corev1.Event {
+	res := make([]corev1.Event, 0)
+	for _, e := range events {
+		if e.InvolvedObject.Kind == "Pod" && pods[e.InvolvedObject.Name] {
+			res = append(res, e)
+		}
+	}
+	sort.SliceStable(res, func(i, j int) bool {
+		return eventTime(&res[i]).Before(eventTime(&res[j]))
+	})
+	return res
+}
+
+// eventTime returns the last time the event occurred
+func eventTime(e *corev1.Event) time.Time {
+	switch {
+	case !e.LastTimestamp.IsZero():
+		return e.LastTimestamp.Time
+	case !e.EventTime.IsZero():
+		return e.EventTime.Time
+	default:
+		return e.FirstTimestamp.Time
+	}
+}
+
+// writeBundleYaml writes v as the YAML file into the tar, or writes `name.error` instead if failed was not nil
+func writeBundleYaml(tw *tar.Writer, name string, v interface{}, failed error) error {
+	if failed == nil {
+		data, err := yaml.Marshal(v)
+		if err == nil {
+			return writeTarEntry(tw, name, bytes.NewReader(data), int64(len(data)))
+		}
+		failed = err
+	}
+	return writeTarEntry(tw, name+".error", strings.NewReader(failed.Error()), int64(len(failed.Error())))
+}
@@ -0,0 +1,138 @@
+package k8s_exec_pod
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"net/http"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSupportBundle(t *testing.T) {
+	web := newRunningPod("web-0", map[string]string{"app": "web"}, "app")
+	web.Spec.NodeName = "node-1"
+	event := func(name, pod string, last time.Time) *corev1.Event {
+		return &corev1.Event{
+			ObjectMeta:     metav1.ObjectMeta{Name: name, Namespace: "default"},
+			InvolvedObject: corev1.ObjectReference{Kind: "Pod", Name: pod},
+			Reason:         name,
+			LastTimestamp:  metav1.Time{Time: last},
+		}
+	}
+	now := time.Now()
+	s, _ := newFakeServer(t, time.Millisecond*100,
+		web,
+		newRunningPod("db-0", map[string]string{"app": "db"}, "db"),
+		event("Started", "web-0", now),
+		event("Pulled", "web-0", now.Add(-time.Minute)),
+		event("Killing", "db-0", now),
+	)
+	defer s.ShutDown()
+
+	res, err := http.Get(fmt.Sprintf("http://%s/cluster/%s/namespace/default/bundle?selector=app%%3Dweb", s.Addr(), fakeClusterName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	zr, err := gzip.NewReader(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := readTar(t, zr)
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	expected := []string{"events.yaml", "nodes.yaml", "pods/web-0/logs/app.log", "pods/web-0/pod.yaml"}
+	if fmt.Sprint(names) != fmt.Sprint(expected) {
+		t.Fatalf("expected files %v, got %v", expected, names)
+	}
+	if files["pods/web-0/logs/app.log"] != "fake logs" {
+		t.Fatalf("unexpected logs %q", files["pods/web-0/logs/app.log"])
+	}
+	if !strings.Contains(files["pods/web-0/pod.yaml"], "kind: Pod") {
+		t.Fatalf("unexpected pod.yaml %q", files["pods/web-0/pod.yaml"])
+	}
+	if !strings.Contains(files["nodes.yaml"], "node: node-1") || strings.Contains(files["nodes.yaml"], "db-0") {
+		t.Fatalf("unexpected nodes.yaml %q", files["nodes.yaml"])
+	}
+	events := files["events.yaml"]
+	pulled, started := strings.Index(events, "reason: Pulled"), strings.Index(events, "reason: Started")
+	if pulled < 0 || started < pulled || strings.Contains(events, "Killing") {
+		t.Fatalf("unexpected events.yaml %q", events)
+	}
+
+	var e HttpResponse
+	getJSON(t, fmt.Sprintf("http://%s/namespace/default/bundle", s.Addr()), &e)
+	if e.Code != CodeError {
+		t.Fatalf("expected an error without the selector, got %+v", e)
+	}
+}
+
+func TestSupportBundleLimits(t *testing.T) {
+	web0 := newRunningPod("web-0", map[string]string{"app": "web"}, "app")
+	web1 := newRunningPod("web-1", map[string]string{"app": "web"}, "app")
+	s, _ := newFakeServerWithOptions(t, &ServerOptions{DrainTimeout: time.Millisecond * 100, MaxBundlePods: 1}, web0, web1)
+	defer s.ShutDown()
+
+	var e HttpResponse
+	getJSON(t, fmt.Sprintf("http://%s/namespace/default/bundle?selector=app%%3Dweb", s.Addr()), &e)
+	if e.Code != CodeError || e.Message != fmt.Sprintf(ErrBundleTooManyPods, 2, 1) {
+		t.Fatalf("expected an error for too many pods, got %+v", e)
+	}
+
+	// the bundle over the limit of the download must be aborted
+	s2, _ := newFakeServerWithOptions(t, &ServerOptions{DrainTimeout: time.Millisecond * 100, MaxDownloadBytes: 2048}, web0, web1)
+	defer s2.ShutDown()
+	res, err := http.Get(fmt.Sprintf("http://%s/namespace/default/bundle?selector=app%%3Dweb&compress=none", s2.Addr()))
+	if err == nil {
+		_, err = ioutil.ReadAll(res.Body)
+		res.Body.Close()
+	}
+	if err == nil {
+		t.Fatal("expected the bundle over the limit was aborted")
+	}
+}
+
+type failedWriter struct{}
+
+func (failedWriter) Write([]byte) (int, error) {
+	return 0, io.ErrClosedPipe
+}
+
+func TestWriteSupportBundleStops(t *testing.T) {
+	k8sClient := fake.NewSimpleClientset(newRunningPod("web-0", map[string]string{"app": "web"}, "app"))
+	option := &ExecOptions{Namespace: "default", Selector: "app=web"}
+
+	// no log was read once the writer failed
+	err := writeTarArchive(failedWriter{}, CompressionNone, 0, func(tw *tar.Writer) error {
+		return WriteSupportBundle(context.Background(), k8sClient, option, true, 0, 0, tw)
+	})
+	if err != io.ErrClosedPipe {
+		t.Fatalf("expected the error of the writer, got %v", err)
+	}
+	for _, action := range k8sClient.Actions() {
+		if action.GetSubresource() == "log" {
+			t.Fatal("unexpected log read after the writer failed")
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = writeTarArchive(ioutil.Discard, CompressionNone, 0, func(tw *tar.Writer) error {
+		return WriteSupportBundle(ctx, k8sClient, option, true, 0, 0, tw)
+	})
+	if err != context.Canceled {
+		t.Fatalf("expected the bundle was stopped by the ctx, got %v", err)
+	}
+}
@@ -5,10 +5,14 @@ import (
 	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
 	"net/url"
+	"os"
+	"sync"
 	"time"
 
 	exec "github.com/nevercase/k8s-exec-pod"
@@ -19,13 +23,35 @@ import (
 )
 
 var (
-	addr string
-	mode string
+	addr      string
+	mode      string
+	cluster   string
+	namespace string
+	pod       string
+	port      int
+	listen    string
+	logQuery  string
+	binary    bool
 )
 
 func init() {
 	flag.StringVar(&addr, "addr", "", "ws addr")
 	flag.StringVar(&mode, "mode", "ssh", "mode")
+	flag.StringVar(&cluster, "cluster", "", "cluster name, the default cluster would be used if empty")
+	flag.StringVar(&namespace, "namespace", "develop", "namespace of the pod for the portforward mode")
+	flag.StringVar(&pod, "pod", "hso-develop-campaign-0", "name of the pod for the portforward mode")
+	flag.IntVar(&port, "port", 0, "port of the pod for the portforward mode")
+	flag.StringVar(&logQuery, "logquery", "", "queries of the log mode, e.g. tailLines=500&timestamps=true")
+	flag.BoolVar(&binary, "binary", false, "send the messages by the binary framing of the subprotocol "+exec.BinarySubprotocol)
+	flag.StringVar(&listen, "listen", "", "local tcp address for the portforward mode, stdin and stdout would be forwarded if empty")
+}
+
+// clusterPrefix returns the cluster segment of the routes
+func clusterPrefix() string {
+	if cluster == "" {
+		return ""
+	}
+	return fmt.Sprintf("/cluster/%s", cluster)
 }
 
 func main() {
@@ -34,6 +60,17 @@ func main() {
 	// set up signals so we handle the first shutdown signal gracefully
 	stopCh := signals.SetupSignalHandler()
 	s := &Service{ctx: context.Background()}
+	if mode == "portforward" {
+		if listen == "" {
+			if err := s.portForward(stdio{}); err != nil {
+				klog.Fatal(err)
+			}
+			return
+		}
+		go s.listenAndForward(listen)
+		<-stopCh
+		return
+	}
 	token, err := getToken(addr)
 	if err != nil {
 		klog.Fatal(err)
@@ -94,7 +131,7 @@ func ping(c *Client) {
 }
 
 func getToken(addr string) (string, error) {
-	requestUrl := fmt.Sprintf("http://%s/namespace/develop/pod/hso-develop-campaign-0/shell/hso-develop-campaign/bash", addr)
+	requestUrl := fmt.Sprintf("http://%s%s/namespace/develop/pod/hso-develop-campaign-0/shell/hso-develop-campaign/bash", addr, clusterPrefix())
 	//requestUrl := fmt.Sprintf("http://%s/namespace/kube-system/pod/traefik-8454d5446b-jdzwl/shell/traefik/bash", addr)
 	res, err := http.Get(requestUrl)
 	if err != nil {
@@ -122,6 +159,132 @@ func getToken(addr string) (string, error) {
 	return result.Token, nil
 }
 
+func getPortForwardToken(addr string) (string, error) {
+	requestUrl := fmt.Sprintf("http://%s%s/namespace/%s/pod/%s/portforward/%d", addr, clusterPrefix(), namespace, pod, port)
+	res, err := http.Get(requestUrl)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		if err := res.Body.Close(); err != nil {
+			klog.Info(err)
+		}
+	}()
+	var result exec.HttpResponse
+	if err = json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return "", err
+	}
+	if result.Code != exec.CodeSuccess {
+		return "", fmt.Errorf("get portforward token err:%s", result.Message)
+	}
+	klog.Info("portforward token:", result.Token)
+	return result.Token, nil
+}
+
+// stdio forwards stdin and stdout like netcat does
+type stdio struct{}
+
+func (stdio) Read(p []byte) (int, error) {
+	return os.Stdin.Read(p)
+}
+
+func (stdio) Write(p []byte) (int, error) {
+	return os.Stdout.Write(p)
+}
+
+func (stdio) Close() error {
+	return nil
+}
+
+// listenAndForward forwards every accepted tcp connection through its own portforward session
+func (s *Service) listenAndForward(listen string) {
+	ln, err := net.Listen("tcp", listen)
+	if err != nil {
+		klog.Fatal(err)
+	}
+	klog.Infof("Forwarding from %s -> %d", ln.Addr(), port)
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			klog.Info(err)
+			return
+		}
+		go func() {
+			if err := s.portForward(conn); err != nil {
+				klog.Info(err)
+			}
+		}()
+	}
+}
+
+// portForward tunnels rw through a new portforward websocket until either side was closed
+func (s *Service) portForward(rw io.ReadWriteCloser) error {
+	defer rw.Close()
+	token, err := getPortForwardToken(addr)
+	if err != nil {
+		return err
+	}
+	ws, err := s.conn(addr, "portforward", token)
+	if err != nil {
+		return err
+	}
+	defer ws.Close()
+	var mu sync.Mutex
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		tick := time.NewTicker(time.Second * 5)
+		defer tick.Stop()
+		for {
+			mu.Lock()
+			err := ws.WriteJSON(exec.TermMsg{MsgType: "ping"})
+			mu.Unlock()
+			if err != nil {
+				return
+			}
+			select {
+			case <-tick.C:
+			case <-done:
+				return
+			}
+		}
+	}()
+	go func() {
+		buf := make([]byte, 32*1024)
+		for {
+			n, err := rw.Read(buf)
+			if n > 0 {
+				mu.Lock()
+				werr := ws.WriteMessage(websocket.BinaryMessage, buf[:n])
+				mu.Unlock()
+				if werr != nil {
+					return
+				}
+			}
+			if err != nil {
+				mu.Lock()
+				_ = ws.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+				mu.Unlock()
+				return
+			}
+		}
+	}()
+	for {
+		messageType, data, err := ws.ReadMessage()
+		if err != nil {
+			klog.V(2).Info(err)
+			return nil
+		}
+		if messageType != websocket.BinaryMessage {
+			klog.Infof("messageType: %d message: %s", messageType, string(data))
+			continue
+		}
+		if _, err = rw.Write(data); err != nil {
+			return err
+		}
+	}
+}
+
 type Service struct {
 	ctx    context.Context
 	cancel context.CancelFunc
@@ -163,9 +326,17 @@ func (s *Service) newClient(addr, mode, token string) (c *Client, err error) {
 }
 
 func (s *Service) conn(addr, mode, token string) (ws *websocket.Conn, err error) {
-	u := url.URL{Scheme: "ws", Host: addr, Path: fmt.Sprintf("/%s/%s", mode, token)}
+	u := url.URL{Scheme: "ws", Host: addr, Path: fmt.Sprintf("%s/%s/%s", clusterPrefix(), mode, token)}
+	if mode == "log" {
+		u.Path = fmt.Sprintf("%s/log/sinceSeconds/0/sinceTime/0/token/%s", clusterPrefix(), token)
+		u.RawQuery = logQuery
+	}
 	klog.Info("url:", u)
-	a, _, err := websocket.DefaultDialer.Dial(u.String(), nil)
+	dialer := *websocket.DefaultDialer
+	if binary {
+		dialer.Subprotocols = []string{exec.BinarySubprotocol}
+	}
+	a, _, err := dialer.Dial(u.String(), nil)
 	if err != nil {
 		klog.V(2).Info(err)
 		return nil, err
@@ -191,6 +362,16 @@ func (s *Service) writePump(c *Client) (err error) {
 			return
 		case msg := <-c.writeChan:
 			klog.Info("writePump get msg:", msg)
+			if termMsg, ok := msg.(exec.TermMsg); ok && binary {
+				data, err := exec.EncodeBinaryTermMsg(&termMsg)
+				if err != nil {
+					return err
+				}
+				if err = c.ws.WriteMessage(websocket.BinaryMessage, data); err != nil {
+					return err
+				}
+				continue
+			}
 			if err = c.ws.WriteJSON(msg); err != nil {
 				return err
 			}
@@ -9,6 +9,10 @@ type HttpResponse struct {
 	Code    int    `json:"code"`
 	Message string `json:"message"`
 	Token   string `json:"token"`
+	// Pod and Container are the resolved target of the token
+	Pod       string      `json:"pod,omitempty"`
+	Container string      `json:"container,omitempty"`
+	Data      interface{} `json:"data,omitempty"`
 }
 
 type TermMsg struct {
@@ -16,6 +20,8 @@ type TermMsg struct {
 	Input   string          `json:"input"`
 	Rows    uint16          `json:"rows"`
 	Cols    uint16          `json:"cols"`
+	// Filter replaces the LogFilter of a log session by the TermFilter message, an empty one clears it
+	Filter *LogFilterSpec `json:"filter,omitempty"`
 }
 
 type TermMessageType string
@@ -24,6 +30,41 @@ const (
 	TermResize TermMessageType = "resize"
 	TermInput  TermMessageType = "input"
 	TermPing   TermMessageType = "ping"
+	TermFilter TermMessageType = "filter"
+	// TermPause and TermResume pause and resume the output of a log session, the output is buffered meanwhile
+	TermPause  TermMessageType = "pause"
+	TermResume TermMessageType = "resume"
+)
+
+// ControlMsg is sent from the server to the client as a websocket.TextMessage,
+// while the output of the process or the stream is always sent as a websocket.BinaryMessage
+type ControlMsg struct {
+	MsgType ControlMessageType `json:"type"`
+	Message string             `json:"message,omitempty"`
+	// Bytes and Total are the progress of ControlUploadProgress, Total would be -1 if it was unknown
+	Bytes int64 `json:"bytes,omitempty"`
+	Total int64 `json:"total,omitempty"`
+	// Count is the number of the frames which were skipped by ControlLogDropped,
+	// or the bytes which were dropped by ControlOutputDropped
+	Count int64 `json:"count,omitempty"`
+}
+
+type ControlMessageType string
+
+const (
+	ControlServerRestarting ControlMessageType = "server_restarting"
+	ControlUploadProgress   ControlMessageType = "upload_progress"
+	ControlUploadCompleted  ControlMessageType = "upload_completed"
+	ControlUploadFailed     ControlMessageType = "upload_failed"
+	// ControlLogStreamStarted and ControlLogStreamStopped carry the `pod/container` of an aggregated log session
+	ControlLogStreamStarted ControlMessageType = "log_stream_started"
+	ControlLogStreamStopped ControlMessageType = "log_stream_stopped"
+	// ControlLogFilterApplied and ControlLogFilterFailed answer the TermFilter message
+	ControlLogFilterApplied ControlMessageType = "log_filter_applied"
+	ControlLogFilterFailed  ControlMessageType = "log_filter_failed"
+	ControlLogDropped       ControlMessageType = "log_dropped"
+	// ControlOutputDropped carries the bytes of the output which were dropped by SlowConsumerDrop
+	ControlOutputDropped ControlMessageType = "output_dropped"
 )
 
 // TerminalSession implements PtyHandler (using a SockJS connection)
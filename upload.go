@@ -0,0 +1,245 @@
+package k8s_exec_pod
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"github.com/Shanghai-Lunara/pkg/zaplogger"
+	"io"
+	"io/ioutil"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+const (
+	ContentTypeTar = "application/x-tar"
+)
+
+const (
+	ErrUploadTarEntryNotAllowed = "error: the tar entry:%v was not allowed, only the regular files and the directories inside the destination were allowed"
+	ErrUploadSessionMismatch    = "error: the session:%v was not of the upload target %s/%s"
+)
+
+// uploadProgressInterval is the min interval of reporting the progress of an upload
+const uploadProgressInterval = time.Millisecond * 500
+
+// Upload extracts the tar stream into the dir of the container by running `tar xf - -C dir`
+func Upload(k8sClient kubernetes.Interface, cfg *rest.Config, option *ExecOptions, dir string, tarStream io.Reader) error {
+	opt := *option
+	opt.Command = []string{"tar", "xf", "-", "-C", dir}
+	opt.Stdin = tarStream
+	var stderr bytes.Buffer
+	if err := ExecStream(k8sClient, cfg, &opt, nil, &stderr); err != nil {
+		return fmt.Errorf("error: upload into %s err:%v stderr:%s", dir, err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// progressReader counts the bytes which were read and reports them every uploadProgressInterval
+type progressReader struct {
+	r      io.Reader
+	n      int64
+	total  int64
+	last   time.Time
+	report func(n, total int64)
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	p.n += int64(n)
+	if p.report != nil && time.Since(p.last) >= uploadProgressInterval {
+		p.last = time.Now()
+		p.report(p.n, p.total)
+	}
+	return n, err
+}
+
+// newUploadTarStream converts the body of the request into a tar stream.
+// A multipart body would be archived by the file names of its parts, a ContentTypeTar body would be re-encoded
+// by spoolUploadTar, any other body would be archived as a single file with the filename.
+// The returned reader must be closed for releasing the converting goroutine or the spooled file.
+func newUploadTarStream(r *http.Request, filename string) (io.ReadCloser, error) {
+	contentType := r.Header.Get("Content-Type")
+	if strings.HasPrefix(contentType, ContentTypeTar) {
+		return spoolUploadTar(r.Body)
+	}
+	var write func(tw *tar.Writer) error
+	if strings.HasPrefix(contentType, "multipart/") {
+		mr, err := r.MultipartReader()
+		if err != nil {
+			return nil, err
+		}
+		write = func(tw *tar.Writer) error {
+			for {
+				part, err := mr.NextPart()
+				if err == io.EOF {
+					return nil
+				}
+				if err != nil {
+					return err
+				}
+				if part.FileName() == "" {
+					continue
+				}
+				if err = writeTarFile(tw, part.FileName(), part, -1); err != nil {
+					return err
+				}
+			}
+		}
+	} else {
+		if filename == "" {
+			return nil, fmt.Errorf("error: the filename was required for the %s body", contentType)
+		}
+		write = func(tw *tar.Writer) error {
+			return writeTarFile(tw, filename, r.Body, r.ContentLength)
+		}
+	}
+	pr, pw := io.Pipe()
+	go func() {
+		tw := tar.NewWriter(pw)
+		err := write(tw)
+		if err == nil {
+			err = tw.Close()
+		}
+		_ = pw.CloseWithError(err)
+	}()
+	return pr, nil
+}
+
+// spoolFile is a temp file which would be removed once it was closed
+type spoolFile struct {
+	*os.File
+}
+
+func (f spoolFile) Close() error {
+	err := f.File.Close()
+	if rmErr := os.Remove(f.Name()); rmErr != nil {
+		zaplogger.Sugar().Error(rmErr)
+	}
+	return err
+}
+
+// spoolUploadTar re-encodes the uploaded tar by copyUploadTar into a temp file, so that all the entries were
+// validated before the extraction started, and a rejected entry could not leave the destination half-extracted.
+// The size of the spooled file is bounded by the body, i.e. ServerOptions.MaxUploadBytes.
+func spoolUploadTar(r io.Reader) (io.ReadCloser, error) {
+	tmp, err := ioutil.TempFile("", "k8s-exec-pod-upload-")
+	if err != nil {
+		return nil, err
+	}
+	f := spoolFile{tmp}
+	tw := tar.NewWriter(f)
+	if err = copyUploadTar(tw, tar.NewReader(r)); err == nil {
+		if err = tw.Close(); err == nil {
+			_, err = f.Seek(0, io.SeekStart)
+		}
+	}
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// copyUploadTar copies the regular files and the directories of the uploaded tar, so that the extraction could not
+// escape the destination directory. The entries of an absolute path or a path out of the archive, and the entries
+// of any other type, e.g. the symlinks and the hardlinks, would fail the upload.
+func copyUploadTar(tw *tar.Writer, tr *tar.Reader) error {
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if header.Typeflag == tar.TypeXGlobalHeader {
+			continue
+		}
+		name := path.Clean(strings.Replace(header.Name, "\\", "/", -1))
+		if path.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") {
+			return fmt.Errorf(ErrUploadTarEntryNotAllowed, header.Name)
+		}
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if name == "." {
+				continue
+			}
+			if err = tw.WriteHeader(&tar.Header{
+				Typeflag: tar.TypeDir,
+				Name:     name + "/",
+				Mode:     header.Mode & 0755,
+				ModTime:  header.ModTime,
+			}); err != nil {
+				return err
+			}
+		case tar.TypeReg, tar.TypeRegA:
+			if name == "." {
+				return fmt.Errorf(ErrUploadTarEntryNotAllowed, header.Name)
+			}
+			if err = tw.WriteHeader(&tar.Header{
+				Typeflag: tar.TypeReg,
+				Name:     name,
+				Mode:     header.Mode & 0755,
+				Size:     header.Size,
+				ModTime:  header.ModTime,
+			}); err != nil {
+				return err
+			}
+			if _, err = io.CopyN(tw, tr, header.Size); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf(ErrUploadTarEntryNotAllowed, header.Name)
+		}
+	}
+}
+
+// writeTarFile writes r as a regular file of the base name into the tar
+func writeTarFile(tw *tar.Writer, name string, r io.Reader, size int64) error {
+	name = path.Base(strings.Replace(name, "\\", "/", -1))
+	if name == "." || name == ".." || name == "/" {
+		return fmt.Errorf("error: invalid filename:%s", name)
+	}
+	return writeTarEntry(tw, name, r, size)
+}
+
+// writeTarEntry writes r as a regular file of the path name into the tar,
+// r would be spooled into a temp file if the size was unknown
+func writeTarEntry(tw *tar.Writer, name string, r io.Reader, size int64) error {
+	if size < 0 {
+		tmp, err := ioutil.TempFile("", "k8s-exec-pod-upload-")
+		if err != nil {
+			return err
+		}
+		defer func() {
+			_ = tmp.Close()
+			if err := os.Remove(tmp.Name()); err != nil {
+				zaplogger.Sugar().Error(err)
+			}
+		}()
+		if size, err = io.Copy(tmp, r); err != nil {
+			return err
+		}
+		if _, err = tmp.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		r = tmp
+	}
+	if err := tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     0644,
+		Size:     size,
+		ModTime:  time.Now(),
+	}); err != nil {
+		return err
+	}
+	_, err := io.CopyN(tw, r, size)
+	return err
+}
@@ -0,0 +1,183 @@
+package k8s_exec_pod
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func readTar(t *testing.T, r io.Reader) map[string]string {
+	res := make(map[string]string)
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return res
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res[header.Name] = string(data)
+	}
+}
+
+func TestNewUploadTarStream(t *testing.T) {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for name, content := range map[string]string{"a.conf": "a=1", "../../b.txt": "b"} {
+		w, err := mw.CreateFormFile("file", name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = w.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := mw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	r, _ := http.NewRequest(http.MethodPost, "/", &body)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	stream, err := newUploadTarStream(r, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if files := readTar(t, stream); len(files) != 2 || files["a.conf"] != "a=1" || files["b.txt"] != "b" {
+		t.Fatalf("unexpected multipart files: %v", files)
+	}
+
+	r, _ = http.NewRequest(http.MethodPost, "/", bytes.NewBufferString("raw"))
+	stream, err = newUploadTarStream(r, "tool.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if files := readTar(t, stream); len(files) != 1 || files["tool.bin"] != "raw" {
+		t.Fatalf("unexpected raw files: %v", files)
+	}
+
+	r, _ = http.NewRequest(http.MethodPost, "/", bytes.NewBufferString("raw"))
+	if _, err = newUploadTarStream(r, ""); err == nil {
+		t.Fatal("expected an error for the raw body without filename")
+	}
+}
+
+func newTarRequest(t *testing.T, headers ...*tar.Header) *http.Request {
+	var body bytes.Buffer
+	tw := tar.NewWriter(&body)
+	for _, header := range headers {
+		if err := tw.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if header.Typeflag == tar.TypeReg {
+			if _, err := tw.Write(bytes.Repeat([]byte("x"), int(header.Size))); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	r, _ := http.NewRequest(http.MethodPost, "/", &body)
+	r.Header.Set("Content-Type", ContentTypeTar)
+	return r
+}
+
+func TestNewUploadTarStreamTar(t *testing.T) {
+	stream, err := newUploadTarStream(newTarRequest(t,
+		&tar.Header{Typeflag: tar.TypeDir, Name: "./", Mode: 0755},
+		&tar.Header{Typeflag: tar.TypeDir, Name: "conf/", Mode: 0755},
+		&tar.Header{Typeflag: tar.TypeReg, Name: "./conf/a.conf", Mode: 04755, Size: 3},
+		&tar.Header{Typeflag: tar.TypeReg, Name: "conf/../b.txt", Mode: 0644, Size: 1},
+	), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if files := readTar(t, stream); len(files) != 3 || files["conf/"] != "" || files["conf/a.conf"] != "xxx" || files["b.txt"] != "x" {
+		t.Fatalf("unexpected tar files: %v", files)
+	}
+
+	for _, header := range []*tar.Header{
+		{Typeflag: tar.TypeReg, Name: "/etc/passwd", Size: 1},
+		{Typeflag: tar.TypeReg, Name: "../../etc/passwd", Size: 1},
+		{Typeflag: tar.TypeReg, Name: "conf/../../passwd", Size: 1},
+		{Typeflag: tar.TypeDir, Name: "../escaped/"},
+		{Typeflag: tar.TypeSymlink, Name: "link", Linkname: "/etc"},
+		{Typeflag: tar.TypeLink, Name: "hard", Linkname: "/etc/shadow"},
+		{Typeflag: tar.TypeChar, Name: "dev"},
+	} {
+		// the whole tar must be rejected before any entry was streamed into the extraction
+		stream, err := newUploadTarStream(newTarRequest(t,
+			&tar.Header{Typeflag: tar.TypeReg, Name: "a.txt", Mode: 0644, Size: 1},
+			header,
+		), "")
+		if err == nil || err.Error() != fmt.Sprintf(ErrUploadTarEntryNotAllowed, header.Name) {
+			if stream != nil {
+				_ = stream.Close()
+			}
+			t.Fatalf("expected the entry:%s was rejected, got err:%v", header.Name, err)
+		}
+	}
+}
+
+func TestPolicyUploadPath(t *testing.T) {
+	p := &Policy{UploadPaths: []string{"/tmp", "/data/"}}
+	for dir, expected := range map[string]string{
+		"/tmp":            "/tmp",
+		"/tmp/a/../b":     "/tmp/b",
+		"/data/x":         "/data/x",
+		"/tmp/../etc":     "",
+		"/tmpfoo":         "",
+		"relative/path":   "",
+		"/etc/kubernetes": "",
+	} {
+		res, err := p.UploadPath(dir)
+		if res != expected || (expected == "") != (err != nil) {
+			t.Fatalf("unexpected upload path of %s: %s err:%v", dir, res, err)
+		}
+	}
+}
+
+func TestUploadSessionMismatch(t *testing.T) {
+	s, _ := newFakeServerWithOptions(t, &ServerOptions{DrainTimeout: time.Millisecond * 100, Policy: &Policy{UploadPaths: []string{"/tmp"}}})
+	defer s.ShutDown()
+	tokens := make(map[string]string)
+	for name, route := range map[string]string{
+		"portforward": "/namespace/default/pod/pod-0/portforward/8080",
+		"other pod":   "/namespace/default/pod/pod-1/shell/app/bash",
+	} {
+		var res HttpResponse
+		getJSON(t, fmt.Sprintf("http://%s%s", s.Addr(), route), &res)
+		if res.Code != CodeSuccess || res.Token == "" {
+			t.Fatalf("unexpected %s token response: %+v", name, res)
+		}
+		tokens[name] = res.Token
+	}
+	for name, token := range tokens {
+		u := fmt.Sprintf("http://%s/namespace/default/pod/pod-0/container/app/upload?path=/tmp&filename=a.txt&session=%s", s.Addr(), token)
+		res, err := http.Post(u, "application/octet-stream", strings.NewReader("a"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var body HttpResponse
+		err = json.NewDecoder(res.Body).Decode(&body)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if body.Code != CodeError {
+			t.Fatalf("expected the %s session was rejected, got %+v", name, body)
+		}
+	}
+}
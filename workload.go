@@ -0,0 +1,184 @@
+package k8s_exec_pod
+
+import (
+	"context"
+	"fmt"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type WorkloadKind string
+
+const (
+	WorkloadDeployment  WorkloadKind = "deployment"
+	WorkloadStatefulSet WorkloadKind = "statefulset"
+	WorkloadDaemonSet   WorkloadKind = "daemonset"
+	WorkloadJob         WorkloadKind = "job"
+)
+
+// PodStrategy decides which ready pod of a workload would be chosen
+type PodStrategy string
+
+const (
+	PodStrategyFirstReady    PodStrategy = "first-ready"
+	PodStrategyRandom        PodStrategy = "random"
+	PodStrategyLeastSessions PodStrategy = "least-sessions"
+	PodStrategyOrdinal       PodStrategy = "ordinal"
+)
+
+const (
+	ErrWorkloadKindNotSupported = "error: the workload kind:%v was not supported"
+	ErrPodStrategyNotSupported  = "error: the pod strategy:%v was not supported"
+	ErrNoReadyPod               = "error: no ready pod was found for %s/%s"
+)
+
+// defaultContainerAnnotation is the annotation kubectl uses to choose the container of a pod
+const defaultContainerAnnotation = "kubectl.kubernetes.io/default-container"
+
+var (
+	podRandMu sync.Mutex
+	podRand   = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
+
+// WorkloadSelector returns the pod selector of the workload
+func WorkloadSelector(k8sClient kubernetes.Interface, namespace string, kind WorkloadKind, name string) (labels.Selector, error) {
+	var selector *metav1.LabelSelector
+	ctx := context.Background()
+	switch kind {
+	case WorkloadDeployment:
+		obj, err := k8sClient.AppsV1().Deployments(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		selector = obj.Spec.Selector
+	case WorkloadStatefulSet:
+		obj, err := k8sClient.AppsV1().StatefulSets(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		selector = obj.Spec.Selector
+	case WorkloadDaemonSet:
+		obj, err := k8sClient.AppsV1().DaemonSets(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		selector = obj.Spec.Selector
+	case WorkloadJob:
+		obj, err := k8sClient.BatchV1().Jobs(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		selector = obj.Spec.Selector
+	default:
+		return nil, fmt.Errorf(ErrWorkloadKindNotSupported, kind)
+	}
+	if selector == nil {
+		return nil, fmt.Errorf("error: the %s/%s has no selector", kind, name)
+	}
+	return metav1.LabelSelectorAsSelector(selector)
+}
+
+// ResolveWorkloadPod chooses a ready pod of the workload by the strategy,
+// the ordinal would only be used by PodStrategyOrdinal
+func ResolveWorkloadPod(k8sClient kubernetes.Interface, sessionHub SessionHub, namespace string, kind WorkloadKind,
+	name string, strategy PodStrategy, ordinal int) (*corev1.Pod, error) {
+	selector, err := WorkloadSelector(k8sClient, namespace, kind, name)
+	if err != nil {
+		return nil, err
+	}
+	list, err := k8sClient.CoreV1().Pods(namespace).List(context.Background(), metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		return nil, err
+	}
+	pods := make([]*corev1.Pod, 0, len(list.Items))
+	for i := range list.Items {
+		pod := &list.Items[i]
+		if pod.DeletionTimestamp != nil || pod.Status.Phase != corev1.PodRunning || !isPodReady(pod) {
+			continue
+		}
+		pods = append(pods, pod)
+	}
+	if len(pods) == 0 {
+		return nil, fmt.Errorf(ErrNoReadyPod, kind, name)
+	}
+	sort.Slice(pods, func(i, j int) bool {
+		return pods[i].Name < pods[j].Name
+	})
+	switch strategy {
+	case "", PodStrategyFirstReady:
+		return pods[0], nil
+	case PodStrategyRandom:
+		podRandMu.Lock()
+		defer podRandMu.Unlock()
+		return pods[podRand.Intn(len(pods))], nil
+	case PodStrategyLeastSessions:
+		counts := make(map[string]int)
+		for _, s := range sessionHub.List() {
+			if s.Option().Namespace == namespace {
+				counts[s.Option().PodName]++
+			}
+		}
+		res := pods[0]
+		for _, pod := range pods[1:] {
+			if counts[pod.Name] < counts[res.Name] {
+				res = pod
+			}
+		}
+		return res, nil
+	case PodStrategyOrdinal:
+		if kind == WorkloadStatefulSet {
+			// the pods of a StatefulSet are named by their ordinals
+			podName := fmt.Sprintf("%s-%d", name, ordinal)
+			for _, pod := range pods {
+				if pod.Name == podName {
+					return pod, nil
+				}
+			}
+			return nil, fmt.Errorf("error: the pod:%s was not ready", podName)
+		}
+		if ordinal < 0 || ordinal >= len(pods) {
+			return nil, fmt.Errorf("error: the ordinal:%d was out of the %d ready pods", ordinal, len(pods))
+		}
+		return pods[ordinal], nil
+	default:
+		return nil, fmt.Errorf(ErrPodStrategyNotSupported, strategy)
+	}
+}
+
+// DefaultContainer returns the container named by the kubectl default-container annotation, or the first one
+func DefaultContainer(pod *corev1.Pod) string {
+	if name := pod.Annotations[defaultContainerAnnotation]; name != "" {
+		return name
+	}
+	if len(pod.Spec.Containers) > 0 {
+		return pod.Spec.Containers[0].Name
+	}
+	return ""
+}
+
+// parseWorkload parses the kind, strategy and ordinal from their string forms
+func parseWorkload(kind, strategy, ordinal string) (WorkloadKind, PodStrategy, int, error) {
+	k := WorkloadKind(strings.ToLower(kind))
+	switch k {
+	case WorkloadDeployment, WorkloadStatefulSet, WorkloadDaemonSet, WorkloadJob:
+	default:
+		return "", "", 0, fmt.Errorf(ErrWorkloadKindNotSupported, kind)
+	}
+	ps := PodStrategy(strings.ToLower(strategy))
+	n := 0
+	if ps == PodStrategyOrdinal {
+		var err error
+		if n, err = strconv.Atoi(ordinal); err != nil {
+			return "", "", 0, fmt.Errorf("error: invalid ordinal:%s err:%v", ordinal, err)
+		}
+	}
+	return k, ps, n, nil
+}
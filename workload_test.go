@@ -0,0 +1,60 @@
+package k8s_exec_pod
+
+import (
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
+	"testing"
+)
+
+func newReadyPod(name string, ready bool) *corev1.Pod {
+	status := corev1.ConditionFalse
+	if ready {
+		status = corev1.ConditionTrue
+	}
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", Labels: map[string]string{"app": "web"}},
+		Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "web"}}},
+		Status: corev1.PodStatus{
+			Phase:      corev1.PodRunning,
+			Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: status}},
+		},
+	}
+}
+
+func TestResolveWorkloadPod(t *testing.T) {
+	k8sClient := fake.NewSimpleClientset(
+		&appsv1.StatefulSet{
+			ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+			Spec:       appsv1.StatefulSetSpec{Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}}},
+		},
+		newReadyPod("web-0", true),
+		newReadyPod("web-1", true),
+		newReadyPod("web-2", false),
+	)
+	sessionHub := NewSessionHub(k8sClient, &rest.Config{})
+
+	pod, err := ResolveWorkloadPod(k8sClient, sessionHub, "default", WorkloadStatefulSet, "web", PodStrategyFirstReady, 0)
+	if err != nil || pod.Name != "web-0" {
+		t.Fatalf("unexpected first-ready pod:%v err:%v", pod, err)
+	}
+	if _, err = sessionHub.New(&ExecOptions{Namespace: "default", PodName: "web-0"}); err != nil {
+		t.Fatal(err)
+	}
+	pod, err = ResolveWorkloadPod(k8sClient, sessionHub, "default", WorkloadStatefulSet, "web", PodStrategyLeastSessions, 0)
+	if err != nil || pod.Name != "web-1" {
+		t.Fatalf("unexpected least-sessions pod:%v err:%v", pod, err)
+	}
+	pod, err = ResolveWorkloadPod(k8sClient, sessionHub, "default", WorkloadStatefulSet, "web", PodStrategyOrdinal, 1)
+	if err != nil || pod.Name != "web-1" {
+		t.Fatalf("unexpected ordinal pod:%v err:%v", pod, err)
+	}
+	if _, err = ResolveWorkloadPod(k8sClient, sessionHub, "default", WorkloadStatefulSet, "web", PodStrategyOrdinal, 2); err == nil {
+		t.Fatal("expected an error for the pod which was not ready")
+	}
+	if _, err = ResolveWorkloadPod(k8sClient, sessionHub, "default", WorkloadDeployment, "web", PodStrategyFirstReady, 0); err == nil {
+		t.Fatal("expected an error for the deployment which was not exist")
+	}
+}